@@ -0,0 +1,181 @@
+package steg
+
+import (
+	"fmt"
+
+	"github.com/zedseven/steg/internal/stats"
+)
+
+// The analysis functions run steganalysis attacks against an image, to check how detectable the data hidden in it is.
+// They look at the values of the colour channels of the image, in the same order that the sequential algorithm uses
+// them (pixel by pixel, channel by channel), skipping fully-transparent pixels. The alpha channel is left out.
+
+const (
+	// chiSquareMinExpected is the smallest expected count of a category for it to be part of a chi-square test, since
+	// the test is unreliable for categories with very few samples.
+	chiSquareMinExpected float64 = 5
+	// defaultWindowDivisor is the number of windows an image is split into if no window size is given.
+	defaultWindowDivisor int64   = 100
+)
+
+// Types
+
+// AnalyzeConfig stores the configuration options for the analysis functions.
+type AnalyzeConfig struct {
+	// ImagePath is the path on disk to a supported image.
+	ImagePath string
+	// Window is the number of channel values in each window of the image that is analyzed on its own. If it's 0, it's
+	// 1% of the image.
+	Window    int64
+	// Step is the number of channel values between the starts of consecutive windows. If it's 0, it's the same as
+	// Window, so that the windows don't overlap.
+	Step      int64
+}
+
+// ChiSquareReport is the result of the chi-square attack on an image.
+type ChiSquareReport struct {
+	// Samples is the number of channel values that were analyzed.
+	Samples     int64
+	// ChiSquare is the chi-square statistic of the whole image.
+	ChiSquare   float64
+	// Probability is the probability that the whole image has data embedded in it.
+	Probability float64
+	// Windows holds the result for each window of the image, in order.
+	Windows     []ChiSquareWindow
+}
+
+// ChiSquareWindow is the result of the chi-square attack on a window of an image.
+type ChiSquareWindow struct {
+	// Start is the index of the first channel value of the window.
+	Start       int64
+	// End is the index just past the last channel value of the window.
+	End         int64
+	// ChiSquare is the chi-square statistic of the window.
+	ChiSquare   float64
+	// Probability is the probability that the window has data embedded in it.
+	Probability float64
+}
+
+// Library methods
+
+// ChiSquareAttack runs the chi-square attack of Westfeld and Pfitzmann against an image. Replacing the least-significant
+// bits of channel values with random data evens out the counts of each pair of values 2k and 2k + 1, so the test
+// measures how close the counts of every pair are to their mean. A probability near 1 means that the values look
+// like they have data embedded in them.
+//
+// The attack is run over the whole image, and over a sliding window along the sequential order, which shows where
+// data was embedded by the sequential algorithm.
+func ChiSquareAttack(config *AnalyzeConfig, logger Logger) (*ChiSquareReport, error) {
+	logger = loggerOrNop(logger)
+
+	samples, bits, err := loadAnalysisSamples(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	window, step := analysisWindow(config, int64(len(samples)))
+
+	logger.Log(OutputSteps, "Running the chi-square attack...")
+	report := &ChiSquareReport{Samples: int64(len(samples))}
+	report.ChiSquare, report.Probability = chiSquare(samples, bits)
+	for start := int64(0); start < int64(len(samples)); start += step {
+		end := start + window
+		if end > int64(len(samples)) {
+			end = int64(len(samples))
+		}
+		w := ChiSquareWindow{Start: start, End: end}
+		w.ChiSquare, w.Probability = chiSquare(samples[start:end], bits)
+		report.Windows = append(report.Windows, w)
+		if end >= int64(len(samples)) {
+			break
+		}
+	}
+	logger.Log(OutputInfo, fmt.Sprintf("The probability of embedding across the whole image is %2.2f%%.",
+		100 * report.Probability))
+
+	return report, nil
+}
+
+// Helper functions
+
+// loadAnalysisSamples loads the image of config and returns its colour channel values in the sequential order, along
+// with the number of bits per channel.
+func loadAnalysisSamples(config *AnalyzeConfig, logger Logger) ([]uint16, uint8, error) {
+	if len(config.ImagePath) <= 0 {
+		return nil, 0, &InvalidFormatError{"ImagePath is empty."}
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Loading the image from '%v'...", config.ImagePath))
+	pixels, info, err := loadImage(config.ImagePath, logger)
+	if err = checkLoaded(pixels, err); err != nil {
+		logger.Log(OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", config.ImagePath))
+		return nil, 0, err
+	}
+
+	samples := analysisSamples(pixels, info)
+	if len(samples) <= 0 {
+		return nil, 0, &InvalidFormatError{"The image has no colour channel values to analyze."}
+	}
+	logger.Log(OutputInfo, fmt.Sprintf("Analyzing %d channel values.", len(samples)))
+	return samples, info.Format.BitsPerChannel, nil
+}
+
+// analysisSamples returns the colour channel values of an image in the sequential order, skipping fully-transparent
+// pixels and the alpha channel.
+func analysisSamples(pixels *pixelBuffer, info imgInfo) []uint16 {
+	supportsAlpha := info.Format.supportsAlpha()
+	alphaChannel := info.Format.alphaChannel()
+	samples := make([]uint16, 0, pixels.count() * int64(info.Format.ChannelsPerPix))
+	for p := int64(0); p < pixels.count(); p++ {
+		if supportsAlpha && pixels.channel(p, uint8(alphaChannel)) <= 0 {
+			continue
+		}
+		for c := uint8(0); c < info.Format.ChannelsPerPix; c++ {
+			if supportsAlpha && int8(c) == alphaChannel {
+				continue
+			}
+			samples = append(samples, pixels.channel(p, c))
+		}
+	}
+	return samples
+}
+
+// analysisWindow returns the window size and step of config for an image of n channel values.
+func analysisWindow(config *AnalyzeConfig, n int64) (window, step int64) {
+	window = config.Window
+	if window <= 0 {
+		window = n / defaultWindowDivisor
+	}
+	if window <= 0 {
+		window = 1
+	}
+	step = config.Step
+	if step <= 0 {
+		step = window
+	}
+	return
+}
+
+// chiSquare runs the chi-square test on the pairs of values of samples, which have bits bits each. It returns the
+// statistic and the probability of embedding, which is 0 if there aren't enough samples to tell.
+func chiSquare(samples []uint16, bits uint8) (float64, float64) {
+	counts := make([]int64, 1 << uint(bits))
+	for _, v := range samples {
+		counts[v]++
+	}
+
+	statistic := float64(0)
+	categories := 0
+	for k := 0; k + 1 < len(counts); k += 2 {
+		expected := float64(counts[k] + counts[k + 1]) / 2
+		if expected < chiSquareMinExpected {
+			continue
+		}
+		diff := float64(counts[k]) - expected
+		statistic += diff * diff / expected
+		categories++
+	}
+	if categories < 2 {
+		return 0, 0
+	}
+	return statistic, stats.ChiSquareSurvival(statistic, categories - 1)
+}
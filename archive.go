@@ -0,0 +1,268 @@
+package steg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Several files (or a whole directory) are hidden together by packing them into a minimal archive first. A full
+// archive format like tar would waste hundreds of bytes of the image per file, so the archive is just a table of
+// contents followed by the contents of every file, back to back:
+//
+//	entry count (2 bytes)
+//	for each entry: name length (2 bytes), name (slash-separated), file size (4 bytes)
+//	the file contents, in the same order
+//
+// All numbers are big-endian. Directories are not stored on their own, only as part of the names of their files.
+// Whether the hidden data is an archive is recorded in the layout settings of the steg header.
+
+const (
+	// archiveMaxEntries is the maximum number of files in an archive.
+	archiveMaxEntries int = 1 << 16 - 1
+	// archiveMaxName is the maximum length of the name of a file in an archive.
+	archiveMaxName    int = 1 << 16 - 1
+)
+
+// Types
+
+// archiveEntry is a single file in an archive.
+type archiveEntry struct {
+	name string
+	data []byte
+}
+
+// Helper functions
+
+// readPayload reads the data to hide for config. A single file is read as-is, while several paths (or a directory)
+// are packed into an archive, in which case archive is true.
+func readPayload(config *HideConfig, logger Logger) (data []byte, archive bool, err error) {
+	paths := config.FilePaths
+	if len(paths) <= 0 {
+		paths = []string{config.FilePath}
+	}
+
+	if len(paths) == 1 {
+		fileInfo, err := os.Stat(paths[0])
+		if err != nil {
+			logger.Log(OutputSteps, fmt.Sprintf("Unable to open the file at '%v'.", paths[0]))
+			return nil, false, err
+		}
+		if !fileInfo.IsDir() {
+			logger.Log(OutputSteps, fmt.Sprintf("Reading the file at '%v'...", paths[0]))
+			if data, err = ioutil.ReadFile(paths[0]); err != nil {
+				logger.Log(OutputSteps, fmt.Sprintf("An error occurred while reading the file '%v'.", paths[0]))
+				return nil, false, err
+			}
+			return data, false, nil
+		}
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Packing %d path(s) into an archive...", len(paths)))
+	if data, err = packArchive(paths, logger); err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// packArchive packs the files at paths into an archive. Directories are added recursively, and every file is named
+// by its path relative to the parent of the path it was found through, so "docs" holding "a.txt" gives "docs/a.txt".
+func packArchive(paths []string, logger Logger) ([]byte, error) {
+	var entries []archiveEntry
+	names := make(map[string]bool)
+	for _, root := range paths {
+		parent := filepath.Dir(filepath.Clean(root))
+		err := filepath.Walk(root, func(p string, fileInfo os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fileInfo.Mode().IsRegular() {
+				if !fileInfo.IsDir() {
+					logger.Log(OutputInfo, fmt.Sprintf("Skipping '%v', since it isn't a regular file.", p))
+				}
+				return nil
+			}
+
+			rel, err := filepath.Rel(parent, p)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+			if names[name] {
+				return &InvalidFormatError{fmt.Sprintf("More than one file would be named '%v' in the archive.", name)}
+			}
+			if len(name) > archiveMaxName {
+				return &InvalidFormatError{fmt.Sprintf("The name '%v' is too long to store in the archive.", name)}
+			}
+			names[name] = true
+
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				logger.Log(OutputSteps, fmt.Sprintf("An error occurred while reading the file '%v'.", p))
+				return err
+			}
+			logger.Log(OutputInfo, fmt.Sprintf("Adding '%v' (%d B) to the archive.", name, len(data)))
+			entries = append(entries, archiveEntry{name, data})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(entries) <= 0 {
+		return nil, &InvalidFormatError{"There are no files to hide."}
+	}
+	if len(entries) > archiveMaxEntries {
+		return nil, &InvalidFormatError{fmt.Sprintf("There are too many files to hide (%d) - the maximum is %d.",
+			len(entries), archiveMaxEntries)}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	b := []byte{byte(len(entries) >> 8), byte(len(entries))}
+	for _, e := range entries {
+		b = append(b, byte(len(e.name) >> 8), byte(len(e.name)))
+		b = append(b, e.name...)
+		b = append(b, byte(len(e.data) >> 24), byte(len(e.data) >> 16), byte(len(e.data) >> 8), byte(len(e.data)))
+	}
+	for _, e := range entries {
+		b = append(b, e.data...)
+	}
+	return b, nil
+}
+
+// parseArchive reads the entries of an archive made by packArchive.
+func parseArchive(b []byte) ([]archiveEntry, error) {
+	malformed := &InvalidFormatError{"The hidden archive is malformed."}
+
+	if len(b) < 2 {
+		return nil, malformed
+	}
+	count := int(b[0]) << 8 | int(b[1])
+	pos := 2
+	entries := make([]archiveEntry, count)
+	sizes := make([]int, count)
+	for i := range entries {
+		if len(b) - pos < 2 {
+			return nil, malformed
+		}
+		nameLength := int(b[pos]) << 8 | int(b[pos + 1])
+		pos += 2
+		if len(b) - pos < nameLength + 4 {
+			return nil, malformed
+		}
+		entries[i].name = string(b[pos:pos + nameLength])
+		pos += nameLength
+		sizes[i] = int(b[pos]) << 24 | int(b[pos + 1]) << 16 | int(b[pos + 2]) << 8 | int(b[pos + 3])
+		pos += 4
+	}
+	for i := range entries {
+		if sizes[i] < 0 || len(b) - pos < sizes[i] {
+			return nil, malformed
+		}
+		entries[i].data = b[pos:pos + sizes[i]]
+		pos += sizes[i]
+	}
+	if pos != len(b) {
+		return nil, malformed
+	}
+	return entries, nil
+}
+
+// extractArchive extracts an archive made by packArchive into the directory outDir, creating it if needed. Every name
+// is checked before anything is written, so that a malicious archive can't write outside of outDir.
+func extractArchive(b []byte, outDir string, logger Logger) error {
+	entries, err := parseArchive(b)
+	if err != nil {
+		return err
+	}
+
+	outPaths := make([]string, len(entries))
+	for i, e := range entries {
+		if outPaths[i], err = archiveEntryPath(outDir, e.name); err != nil {
+			return err
+		}
+	}
+
+	for i, e := range entries {
+		logger.Log(OutputInfo, fmt.Sprintf("Extracting '%v' (%d B)...", e.name, len(e.data)))
+		if err = os.MkdirAll(filepath.Dir(outPaths[i]), 0755); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(outPaths[i], e.data, 0644); err != nil {
+			logger.Log(OutputSteps, fmt.Sprintf("There was an error creating the file '%v'.", outPaths[i]))
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveEntryPath returns the path on disk to extract the archive entry name to, within outDir. Names that are
+// absolute, not in their clean form, or that would escape outDir are rejected, and so are names that lead through
+// anything below outDir that's already a symbolic link, since creating the file would follow it out of outDir. outDir
+// itself may be a symbolic link, since it's chosen by the user rather than by the archive.
+func archiveEntryPath(outDir, name string) (string, error) {
+	unsafe := &InvalidFormatError{fmt.Sprintf("The hidden archive contains the unsafe file name '%v'.", name)}
+
+	if len(name) <= 0 || path.IsAbs(name) || strings.ContainsAny(name, "\\:\x00") || path.Clean(name) != name {
+		return "", unsafe
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." || part == "." {
+			return "", unsafe
+		}
+	}
+
+	p := filepath.Join(outDir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(outDir, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+		return "", unsafe
+	}
+
+	existing := outDir
+	for _, part := range strings.Split(name, "/") {
+		existing = filepath.Join(existing, part)
+		fileInfo, err := os.Lstat(existing)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if fileInfo.Mode() & os.ModeSymlink != 0 {
+			return "", &InvalidFormatError{fmt.Sprintf("The hidden archive would be extracted through the symbolic " +
+				"link '%v'.", existing)}
+		}
+	}
+	return p, nil
+}
+
+// writePayload writes the data that was dug up to outPath. If it's an archive, it's extracted into outPath as a
+// directory instead.
+func writePayload(data []byte, archive bool, outPath string, logger Logger) error {
+	if archive {
+		logger.Log(OutputSteps, fmt.Sprintf("Extracting the archive into '%v'...", outPath))
+		return extractArchive(data, outPath, logger)
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Writing to the output file at '%v'...", outPath))
+	outFile, err := os.Create(outPath)
+	if err != nil {
+		logger.Log(OutputSteps, fmt.Sprintf("There was an error creating the file '%v'.", outPath))
+		return err
+	}
+
+	if _, err = outFile.Write(data); err != nil {
+		_ = outFile.Close()
+		return err
+	}
+	if err = outFile.Close(); err != nil {
+		logger.Log(OutputSteps, "Error closing the file.", "error", err)
+		return err
+	}
+	return nil
+}
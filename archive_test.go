@@ -0,0 +1,210 @@
+package steg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests
+
+func TestArchiveEntryPath(t *testing.T) {
+	outDir, err := ioutil.TempDir("", "steg-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"a.txt", true},
+		{"docs/a.txt", true},
+		{"docs/deeper/a.txt", true},
+		{"..a.txt", true},
+		{"", false},
+		{"..", false},
+		{"../a.txt", false},
+		{"docs/../../a.txt", false},
+		{"docs/..", false},
+		{".", false},
+		{"./a.txt", false},
+		{"docs/./a.txt", false},
+		{"docs//a.txt", false},
+		{"docs/", false},
+		{"/a.txt", false},
+		{"/etc/passwd", false},
+		{"\\a.txt", false},
+		{"docs\\a.txt", false},
+		{"..\\a.txt", false},
+		{"C:a.txt", false},
+		{"C:\\a.txt", false},
+		{"docs/a.txt:stream", false},
+		{"a.txt\x00.png", false},
+		{"\x00", false},
+	}
+	for _, test := range tests {
+		p, err := archiveEntryPath(outDir, test.name)
+		if test.ok {
+			if err != nil {
+				t.Errorf("%q was rejected: %v", test.name, err)
+			} else if want := filepath.Join(outDir, filepath.FromSlash(test.name)); p != want {
+				t.Errorf("%q goes to %q, want %q", test.name, p, want)
+			}
+		} else if err == nil {
+			t.Errorf("%q was accepted, as %q", test.name, p)
+		}
+	}
+}
+
+func TestArchiveEntryPathSymlinks(t *testing.T) {
+	outDir, err := ioutil.TempDir("", "steg-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+	target, err := ioutil.TempDir("", "steg-archive-target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+
+	if err = os.Symlink(target, filepath.Join(outDir, "link")); err != nil {
+		t.Skipf("Symbolic links can't be made here: %v", err)
+	}
+	if err = os.Symlink(filepath.Join(target, "a.txt"), filepath.Join(outDir, "file")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(filepath.Join(outDir, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"a.txt", true},
+		{"docs/a.txt", true},
+		{"new/a.txt", true},
+		{"link", false},
+		{"link/a.txt", false},
+		{"link/deeper/a.txt", false},
+		{"file", false},
+	}
+	for _, test := range tests {
+		if _, err := archiveEntryPath(outDir, test.name); (err == nil) != test.ok {
+			t.Errorf("%q: got error %v, want ok = %v", test.name, err, test.ok)
+		}
+	}
+
+	// Nothing is written if any entry is rejected, even the ones before it
+	b := archiveBytes(t, []archiveEntry{{"a.txt", []byte("a")}, {"link/b.txt", []byte("b")}})
+	if err = extractArchive(b, outDir, loggerOrNop(nil)); err == nil {
+		t.Fatal("An archive that writes through a symbolic link was extracted.")
+	}
+	for _, p := range []string{filepath.Join(outDir, "a.txt"), filepath.Join(target, "b.txt")} {
+		if _, err = os.Lstat(p); !os.IsNotExist(err) {
+			t.Errorf("'%v' was written.", p)
+		}
+	}
+}
+
+func TestParseArchive(t *testing.T) {
+	valid := archiveBytes(t, []archiveEntry{{"a.txt", []byte("hello")}, {"docs/b.txt", []byte("world!")}})
+
+	tests := []struct {
+		name string
+		b    []byte
+		ok   bool
+	}{
+		{"valid", valid, true},
+		{"empty archive", []byte{0, 0}, true},
+		{"empty file", archiveBytes(t, []archiveEntry{{"a.txt", nil}}), true},
+		{"no bytes", nil, false},
+		{"truncated entry count", []byte{0}, false},
+		{"missing entries", []byte{0, 1}, false},
+		{"truncated name length", []byte{0, 1, 0}, false},
+		{"truncated name", []byte{0, 1, 0, 5, 'a', '.'}, false},
+		{"truncated file size", []byte{0, 1, 0, 1, 'a', 0, 0, 0}, false},
+		{"truncated contents", valid[:len(valid) - 1], false},
+		{"trailing bytes", append(append([]byte(nil), valid...), 0), false},
+		{"entry count too large", append([]byte{0, 3}, valid[2:]...), false},
+		{"entry count too small", append([]byte{0, 1}, valid[2:]...), false},
+		{"oversized name", []byte{0, 1, 0xff, 0xff, 'a', 0, 0, 0, 0}, false},
+		{"oversized file", []byte{0, 1, 0, 1, 'a', 0xff, 0xff, 0xff, 0xff, 'x'}, false},
+		{"oversized table of contents", []byte{0xff, 0xff, 0, 1, 'a', 0, 0, 0, 0}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := parseArchive(test.b)
+			if !test.ok {
+				if err == nil {
+					t.Fatalf("A malformed archive was parsed into %d entries.", len(entries))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if repacked := archiveBytes(t, entries); string(repacked) != string(test.b) {
+				t.Fatalf("The entries pack back up into %v, want %v", repacked, test.b)
+			}
+		})
+	}
+}
+
+func TestPackArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "steg-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{"docs/a.txt": "hello", "docs/deeper/b.txt": "world!", "docs/empty": ""}
+	for name, contents := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := packArchive([]string{filepath.Join(dir, "docs")}, loggerOrNop(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "out")
+	if err = extractArchive(b, outDir, loggerOrNop(nil)); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		got, err := ioutil.ReadFile(filepath.Join(outDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != contents {
+			t.Errorf("'%v' holds %q, want %q", name, got, contents)
+		}
+	}
+}
+
+// Helper functions
+
+// archiveBytes lays entries out the same way as packArchive, without checking them, so that bad names can be tested.
+func archiveBytes(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	b := []byte{byte(len(entries) >> 8), byte(len(entries))}
+	for _, e := range entries {
+		b = append(b, byte(len(e.name) >> 8), byte(len(e.name)))
+		b = append(b, e.name...)
+		b = append(b, byte(len(e.data) >> 24), byte(len(e.data) >> 16), byte(len(e.data) >> 8), byte(len(e.data)))
+	}
+	for _, e := range entries {
+		b = append(b, e.data...)
+	}
+	return b
+}
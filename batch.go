@@ -0,0 +1,268 @@
+package steg
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A batch runs many hide and dig jobs at once, from a manifest. Manifests are either JSON (an array of job objects) or
+// CSV (a header row naming the columns, then a row for each job), with the same fields in both: op (hide or dig),
+// image, file (the file to hide, for hide jobs), out, config (the path to an options file, as with -config) and options
+// (options inline - an object of keys and values in JSON, or key=value pairs separated by semicolons in CSV). Inline
+// options take precedence over the ones from the config file.
+//
+// Jobs run concurrently, and each one succeeds or fails on its own, so one bad image doesn't stop the rest. Relative
+// paths in a manifest are relative to the working directory, not to the manifest.
+
+// Types
+
+// BatchJob is a single job of a batch.
+type BatchJob struct {
+	// Op is the operation to run, either "hide" or "dig".
+	Op      string            `json:"op"`
+	// Image is the path on disk to the image to hide in or dig from.
+	Image   string            `json:"image"`
+	// File is the path on disk to the file (or directory) to hide. It's not used by dig jobs.
+	File    string            `json:"file,omitempty"`
+	// Out is the path on disk to write the steg image to, or the file that was dug up.
+	Out     string            `json:"out"`
+	// Config is the path on disk to a file of saved options (see ParseOptions). It's optional.
+	Config  string            `json:"config,omitempty"`
+	// Options are options given inline, by the same keys as in an options file. They take precedence over Config.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// BatchResult is the outcome of a single job of a batch.
+type BatchResult struct {
+	// Index is the position of the job in the manifest, from 0.
+	Index    int     `json:"index"`
+	// Op, Image and Out are copied from the job.
+	Op       string  `json:"op"`
+	Image    string  `json:"image"`
+	Out      string  `json:"out"`
+	// OK is whether the job succeeded.
+	OK       bool    `json:"ok"`
+	// Error explains why the job failed, if it did.
+	Error    string  `json:"error,omitempty"`
+	// Seconds is how long the job took.
+	Seconds  float64 `json:"seconds"`
+}
+
+// Library methods
+
+// ReadManifest reads the jobs of a batch from the manifest at path. It's read as CSV if its extension is ".csv", and
+// as JSON otherwise.
+func ReadManifest(path string) ([]BatchJob, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		var jobs []BatchJob
+		if err = json.Unmarshal(text, &jobs); err != nil {
+			return nil, &InvalidFormatError{fmt.Sprintf("The manifest '%v' is not valid JSON: %v", path, err.Error())}
+		}
+		return jobs, nil
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(string(text))).ReadAll()
+	if err != nil {
+		return nil, &InvalidFormatError{fmt.Sprintf("The manifest '%v' is not valid CSV: %v", path, err.Error())}
+	}
+	if len(rows) <= 0 {
+		return nil, nil
+	}
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"op", "image", "out"} {
+		if _, ok := columns[name]; !ok {
+			return nil, &InvalidFormatError{fmt.Sprintf("The manifest '%v' has no '%v' column.", path, name)}
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	jobs := make([]BatchJob, len(rows) - 1)
+	for r, row := range rows[1:] {
+		jobs[r] = BatchJob{
+			Op:     field(row, "op"),
+			Image:  field(row, "image"),
+			File:   field(row, "file"),
+			Out:    field(row, "out"),
+			Config: field(row, "config"),
+		}
+		for _, pair := range strings.Split(field(row, "options"), ";") {
+			if pair = strings.TrimSpace(pair); len(pair) <= 0 {
+				continue
+			}
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 {
+				return nil, &InvalidFormatError{fmt.Sprintf("Row %d of the manifest '%v' has options that are not in " +
+					"the format key=value.", r + 2, path)}
+			}
+			if jobs[r].Options == nil {
+				jobs[r].Options = make(map[string]string)
+			}
+			jobs[r].Options[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return jobs, nil
+}
+
+// RunBatch runs jobs on up to workers goroutines at once (or GOMAXPROCS if workers is 0), and returns the result of
+// every one of them, in the same order. Jobs that haven't started yet when ctx is cancelled fail with the error of ctx.
+func RunBatch(ctx context.Context, jobs []BatchJob, workers int, logger Logger) []BatchResult {
+	logger = loggerOrNop(logger)
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	results := make([]BatchResult, len(jobs))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i] = runBatchJob(ctx, i, jobs[i])
+				if results[i].OK {
+					logger.Log(OutputInfo, fmt.Sprintf("Job %d (%v '%v') is done.", i, jobs[i].Op, jobs[i].Image))
+				} else {
+					logger.Log(OutputSteps, fmt.Sprintf("Job %d (%v '%v') failed: %v", i, jobs[i].Op, jobs[i].Image,
+						results[i].Error))
+				}
+			}
+		}()
+	}
+	logger.Log(OutputSteps, fmt.Sprintf("Running %d job(s) on %d worker(s)...", len(jobs), workers))
+	for i := range jobs {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	return results
+}
+
+// WriteBatchResults writes the results of a batch to path. It's written as CSV if its extension is ".csv", and as JSON
+// otherwise.
+func WriteBatchResults(path string, results []BatchResult) error {
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		text, err := json.MarshalIndent(results, "", "\t")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, append(text, '\n'), 0644)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"index", "op", "image", "out", "ok", "error", "seconds"})
+	for _, r := range results {
+		_ = w.Write([]string{fmt.Sprint(r.Index), r.Op, r.Image, r.Out, fmt.Sprint(r.OK), r.Error,
+			fmt.Sprintf("%.3f", r.Seconds)})
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Helper functions
+
+// runBatchJob runs a single job of a batch. Panics are recovered, so that they only fail the job they happened in -
+// forEachChunk raises the panics of its goroutines again on the job's, so those are caught too.
+func runBatchJob(ctx context.Context, index int, job BatchJob) (result BatchResult) {
+	result = BatchResult{Index: index, Op: job.Op, Image: job.Image, Out: job.Out}
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			result.OK, result.Error = false, fmt.Sprintf("panic: %v", r)
+		}
+		result.Seconds = time.Since(start).Seconds()
+	}()
+
+	err := ctx.Err()
+	if err == nil {
+		err = runBatchOp(ctx, job)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	result.OK = true
+	return
+}
+
+// runBatchOp parses the options of a job and runs its operation.
+func runBatchOp(ctx context.Context, job BatchJob) error {
+	opts, err := batchJobOptions(job)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(job.Op) {
+	case "hide":
+		_, err = HideContext(ctx, &HideConfig{ImagePath: job.Image, FilePath: job.File, OutPath: job.Out, Options: opts},
+			nil, nil)
+	case "dig":
+		err = DigContext(ctx, &DigConfig{ImagePath: job.Image, OutPath: job.Out, Options: opts}, nil, nil)
+	default:
+		err = &InvalidFormatError{fmt.Sprintf("Unknown operation '%v' - it must be hide or dig.", job.Op)}
+	}
+	return err
+}
+
+// batchJobOptions builds the Options of a job from its options file, with its inline options applied on top. The
+// inline options are parsed one at a time instead of being added to the text of the file, so that a value holding a
+// line break can't slip in settings of its own.
+func batchJobOptions(job BatchJob) (*Options, error) {
+	opts := NewOptions()
+	if len(job.Config) > 0 {
+		text, err := ioutil.ReadFile(job.Config)
+		if err != nil {
+			return nil, err
+		}
+		if opts, err = ParseOptions(text); err != nil {
+			return nil, err
+		}
+	}
+
+	// The keys are sorted so that the options are applied in the same order every time
+	keys := make([]string, 0, len(job.Options))
+	for key := range job.Options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		opt, err := parseOption(strings.TrimSpace(key), strings.TrimSpace(job.Options[key]))
+		if err != nil {
+			return nil, &InvalidFormatError{fmt.Sprintf("The inline option '%v' is invalid: %v", key, err.Error())}
+		}
+		opts = opts.With(opt)
+	}
+	return opts, nil
+}
@@ -0,0 +1,119 @@
+package steg
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zedseven/steg/internal/algos"
+)
+
+// Tests
+
+// TestBatchManifest hides and digs files through JSON and CSV manifests, with options from a config file and inline.
+func TestBatchManifest(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	imagePath := writeTestImage(t, dir)
+	filePath, data := writeTestFile(t, dir, 500)
+	configPath := filepath.Join(dir, "options.txt")
+	if err := ioutil.WriteFile(configPath, []byte("passphrase=from the config\nerrors=8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifests := []struct {
+		name, hide, dig, out string
+	}{
+		{"manifest.json",
+			`[{"op": "hide", "image": "` + imagePath + `", "file": "` + filePath + `", "out": "` + dir +
+				`/json.png", "config": "` + configPath + `", "options": {"passphrase": "inline"}}]`,
+			`[{"op": "dig", "image": "` + dir + `/json.png", "out": "` + dir + `/json.bin", "config": "` + configPath +
+				`", "options": {"passphrase": "inline"}},
+			{"op": "dig", "image": "` + dir + `/json.png", "out": "` + dir + `/wrong.bin", "config": "` + configPath +
+				`"}]`,
+			"json.bin"},
+		{"manifest.csv",
+			"op,image,file,out,config,options\nhide," + imagePath + "," + filePath + "," + dir + "/csv.png," +
+				configPath + ",passphrase=inline;errors=12\n",
+			"op,image,out,config,options\ndig," + dir + "/csv.png," + dir + "/csv.bin," + configPath +
+				",passphrase=inline\ndig," + dir + "/csv.png," + dir + "/wrong.bin," + configPath + ",\n",
+			"csv.bin"},
+	}
+	for _, m := range manifests {
+		t.Run(m.name, func(t *testing.T) {
+			// The second dig job only has the passphrase from the config, which the inline one replaced
+			for step, text := range []string{m.hide, m.dig} {
+				results := runTestManifest(t, filepath.Join(dir, m.name), text)
+				for i, result := range results {
+					if wantOK := step == 0 || i == 0; result.OK != wantOK {
+						t.Fatalf("Job %d of step %d: ok = %v, want %v (%v)", i, step, result.OK, wantOK, result.Error)
+					}
+				}
+			}
+			got, err := ioutil.ReadFile(filepath.Join(dir, m.out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("The file that was dug up is wrong.")
+			}
+		})
+	}
+}
+
+// TestBatchJobOptions checks that inline options are taken one at a time, so that a line break in a value can't add
+// settings of its own.
+func TestBatchJobOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]string
+		ok      bool
+		want    *Options
+	}{
+		{"none", nil, true, NewOptions()},
+		{"plain", map[string]string{"algo": "sequential", "passphrase": "hunter2"}, true,
+			NewOptions(WithAlgorithm(algos.AlgoSequential), WithPassphrase("hunter2"))},
+		{"line break in a value", map[string]string{"algo": "pattern", "passphrase": "hunter2\nalgo=sequential"}, true,
+			NewOptions(WithPassphrase("hunter2\nalgo=sequential"))},
+		{"line break in a key", map[string]string{"passphrase": "hunter2", "x\nalgo": "sequential"}, false, nil},
+		{"equals sign in a key", map[string]string{"algo=sequential\npassphrase": "hunter2"}, false, nil},
+		{"unknown key", map[string]string{"colour": "blue"}, false, nil},
+		{"invalid value", map[string]string{"algo": "sideways"}, false, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts, err := batchJobOptions(BatchJob{Options: test.options})
+			if !test.ok {
+				if err == nil {
+					t.Fatal("The options were accepted.")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.algorithm != test.want.algorithm || opts.passphrase != test.want.passphrase {
+				t.Fatalf("got algorithm %v and passphrase %q, want %v and %q", opts.algorithm, opts.passphrase,
+					test.want.algorithm, test.want.passphrase)
+			}
+		})
+	}
+}
+
+// Helper functions
+
+// runTestManifest writes text to a manifest at path, and runs it.
+func runTestManifest(t *testing.T, path, text string) []BatchResult {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := ReadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return RunBatch(context.Background(), jobs, 0, nil)
+}
@@ -0,0 +1,169 @@
+package steg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// A bit plane is a single bit of a single channel, taken from every pixel of an image. Rendering one shows which bits
+// the algorithms touched: the least-significant planes of a clean image still show the shapes of the picture, while
+// embedded data shows up as noise. Bits are numbered the same way as everywhere else, from 0 for the least-significant
+// bit upwards.
+
+const (
+	// bitPlaneGap is the width in pixels of the gaps between the tiles of a contact sheet.
+	bitPlaneGap int = 4
+)
+
+var (
+	bitPlaneOn  = color.Gray{0xff}
+	bitPlaneOff = color.Gray{0x00}
+	bitPlaneBg  = color.Gray{0x80}
+)
+
+// Types
+
+// BitPlaneConfig stores the configuration options for BitPlane.
+type BitPlaneConfig struct {
+	// ImagePath is the path on disk to a supported image.
+	ImagePath string
+	// OutPath is the path on disk to write the rendered PNG to.
+	OutPath   string
+	// Channels are the channels to render, by their letters (r, g, b and a for RGBA images, c, m, y and k for CMYK ones,
+	// g for greyscale ones and a for alpha-only ones). If it's empty, every channel but alpha is used.
+	Channels  string
+	// Bits are the bits of the channels to render, from 0 for the least-significant bit. If it's empty, only bit 0 is
+	// used.
+	Bits      []uint8
+	// All renders a contact sheet of every bit of every channel instead, with a row for each channel, and the bits from
+	// the least-significant to the most-significant going across. Channels and Bits are ignored.
+	All       bool
+}
+
+// Library methods
+
+// BitPlane renders bit planes of the image at config.ImagePath as a black-and-white PNG. If several channels or bits
+// are chosen, they're combined with XOR, so a pixel is white when an odd number of the chosen bits are set.
+func BitPlane(config *BitPlaneConfig, logger Logger) error {
+	logger = loggerOrNop(logger)
+
+	if len(config.ImagePath) <= 0 {
+		return &InvalidFormatError{"ImagePath is empty."}
+	}
+	if len(config.OutPath) <= 0 {
+		return &InvalidFormatError{"OutPath is empty."}
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Loading the image from '%v'...", config.ImagePath))
+	pixels, info, err := loadImage(config.ImagePath, logger)
+	if err = checkLoaded(pixels, err); err != nil {
+		logger.Log(OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", config.ImagePath))
+		return err
+	}
+
+	var out *image.Gray
+	if config.All {
+		logger.Log(OutputSteps, "Rendering every bit plane...")
+		out = bitPlaneSheet(pixels, info)
+	} else {
+		channels, err := parseChannelLetters(config.Channels, info)
+		if err != nil {
+			return err
+		}
+		bits := config.Bits
+		if len(bits) <= 0 {
+			bits = []uint8{0}
+		}
+		for _, b := range bits {
+			if b >= info.Format.BitsPerChannel {
+				return &InvalidFormatError{fmt.Sprintf("Bit %d is out of range, since the image has %d bits per " +
+					"channel.", b, info.Format.BitsPerChannel)}
+			}
+		}
+
+		logger.Log(OutputSteps, "Rendering the bit plane...")
+		out = image.NewGray(image.Rect(0, 0, int(info.W), int(info.H)))
+		drawBitPlane(out, image.Point{}, pixels, info, channels, bits)
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Writing the bit plane to '%v' now...", config.OutPath))
+	if err = writePNG(out, config.OutPath); err != nil {
+		logger.Log(OutputSteps, "An error occurred while writing the bit plane.")
+		return err
+	}
+
+	logger.Log(OutputSteps, "All done! c:")
+	return nil
+}
+
+// Helper functions
+
+// parseChannelLetters parses the channel letters of a BitPlaneConfig into channel indices. If letters is empty, every
+// channel but alpha is returned.
+func parseChannelLetters(letters string, info imgInfo) ([]uint8, error) {
+	names := channelNames(info.Format.Model)
+	var channels []uint8
+	if len(letters) <= 0 {
+		for c := uint8(0); c < info.Format.ChannelsPerPix; c++ {
+			if !info.Format.supportsAlpha() || int8(c) != info.Format.alphaChannel() {
+				channels = append(channels, c)
+			}
+		}
+		return channels, nil
+	}
+
+	known := channelLetters(info.Format.Model)
+	for _, l := range strings.ToLower(letters) {
+		c := strings.IndexRune(known, l)
+		if c < 0 || c >= int(info.Format.ChannelsPerPix) {
+			described := make([]string, info.Format.ChannelsPerPix)
+			for i := range described {
+				described[i] = fmt.Sprintf("%c (%v)", known[i], names[i])
+			}
+			return nil, &InvalidFormatError{fmt.Sprintf("The image has no channel '%c'. Its channels are %v.", l,
+				strings.Join(described, ", "))}
+		}
+		channels = append(channels, uint8(c))
+	}
+	return channels, nil
+}
+
+// drawBitPlane draws the XOR of the given bits of the given channels of every pixel onto out, with the top-left corner
+// at at.
+func drawBitPlane(out *image.Gray, at image.Point, pixels *pixelBuffer, info imgInfo, channels, bits []uint8) {
+	w := int64(info.W)
+	for p := int64(0); p < pixels.count(); p++ {
+		set := uint16(0)
+		for _, c := range channels {
+			v := pixels.channel(p, c)
+			for _, b := range bits {
+				set ^= v >> b & 1
+			}
+		}
+		px := bitPlaneOff
+		if set != 0 {
+			px = bitPlaneOn
+		}
+		out.SetGray(at.X + int(p % w), at.Y + int(p / w), px)
+	}
+}
+
+// bitPlaneSheet renders a contact sheet of every bit plane of an image, with a row for each channel and a column for
+// each bit.
+func bitPlaneSheet(pixels *pixelBuffer, info imgInfo) *image.Gray {
+	w, h := int(info.W), int(info.H)
+	cols, rows := int(info.Format.BitsPerChannel), int(info.Format.ChannelsPerPix)
+	out := image.NewGray(image.Rect(0, 0, cols * (w + bitPlaneGap) - bitPlaneGap, rows * (h + bitPlaneGap) - bitPlaneGap))
+	for i := range out.Pix {
+		out.Pix[i] = bitPlaneBg.Y
+	}
+	for c := 0; c < rows; c++ {
+		for b := 0; b < cols; b++ {
+			at := image.Pt(b * (w + bitPlaneGap), c * (h + bitPlaneGap))
+			drawBitPlane(out, at, pixels, info, []uint8{uint8(c)}, []uint8{uint8(b)})
+		}
+	}
+	return out
+}
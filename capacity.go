@@ -0,0 +1,198 @@
+package steg
+
+import (
+	"fmt"
+
+	"github.com/zedseven/steg/internal/algos"
+	"github.com/zedseven/steg/internal/util"
+)
+
+// Types
+
+// CapacityReport describes how much data can be hidden in an image (or a set of images) with a given configuration.
+type CapacityReport struct {
+	// UsableBits is the number of places in the image that bits can be hidden in. For AlgoRobust, these are the cells
+	// of the image, and each bit takes up several of them.
+	UsableBits  int64
+	// HeaderBits is the number of UsableBits taken up by the steg header.
+	HeaderBits  int64
+	// MaxFileSize is the size in bytes of the largest file that can be hidden in the image, after compression.
+	MaxFileSize int64
+	// FileSize is the size in bytes of the file at FilePath (or the archive of FilePaths), or 0 if neither is set.
+	FileSize    int64
+	// StoredSize is the size in bytes of the file once compressed, which is what is compared against MaxFileSize. It is
+	// the same as FileSize if compression is off or doesn't help.
+	StoredSize  int64
+	// Fits is whether the file can be hidden in the image. It is always false if neither FilePath nor FilePaths is set.
+	Fits        bool
+}
+
+// Primary method
+
+// Capacity determines how much data can be hidden in the image at config.ImagePath with the rest of the
+// configuration. If config.ImagePaths is set, it's the total across all of those images instead, for splitting a file
+// across them - or if the Options have a threshold, the least of them, since every image holds a share of the whole
+// file. OutPath and OutPaths are not used, and neither is the pattern key. FilePath and FilePaths are optional - if one
+// is set, the file is read (and packed and compressed) as Hide would, to report whether it fits.
+func Capacity(config *HideConfig, logger Logger) (*CapacityReport, error) {
+	logger = loggerOrNop(logger)
+
+	// Input validation
+	imagePaths := config.ImagePaths
+	if len(imagePaths) <= 0 {
+		if len(config.ImagePath) <= 0 {
+			return nil, &InvalidFormatError{"ImagePath is empty."}
+		}
+		imagePaths = []string{config.ImagePath}
+	}
+	opts := config.Options
+	if opts == nil {
+		opts = NewOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	report := &CapacityReport{}
+	for i, imagePath := range imagePaths {
+		logger.Log(OutputSteps, fmt.Sprintf("Loading the image from '%v'...", imagePath))
+		pixels, info, err := loadImage(imagePath, logger)
+		if err != nil {
+			logger.Log(OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", imagePath))
+			return nil, err
+		}
+		imageReport, err := imageCapacity(pixels, info, opts, logger)
+		if err != nil {
+			return nil, err
+		}
+		report.UsableBits += imageReport.UsableBits
+		report.HeaderBits += imageReport.HeaderBits
+		if opts.threshold <= 0 {
+			report.MaxFileSize += imageReport.MaxFileSize
+		} else if i == 0 || imageReport.MaxFileSize < report.MaxFileSize {
+			report.MaxFileSize = imageReport.MaxFileSize
+		}
+	}
+
+	if len(imagePaths) > 1 {
+		logger.Log(OutputInfo, fmt.Sprintf("The %d images can hold a file of up to %d B between them.", len(imagePaths),
+			report.MaxFileSize))
+	}
+
+	if len(config.FilePath) > 0 || len(config.FilePaths) > 0 {
+		data, _, err := readPayload(config, logger)
+		if err != nil {
+			return nil, err
+		}
+		report.FileSize = int64(len(data))
+		if data, _, err = preparePayload(data, opts, logger); err != nil {
+			return nil, err
+		}
+		report.StoredSize = int64(len(data))
+		report.Fits = report.StoredSize <= report.MaxFileSize
+
+		if report.Fits {
+			logger.Log(OutputInfo, fmt.Sprintf("The file takes up %d B of it, so it fits.", report.StoredSize))
+		} else {
+			logger.Log(OutputInfo, fmt.Sprintf("The file takes up %d B, so it doesn't fit.", report.StoredSize))
+		}
+	}
+
+	return report, nil
+}
+
+// Helper functions
+
+// imageCapacity determines how much data can be hidden in a loaded image with opts.
+func imageCapacity(pixels *pixelBuffer, info imgInfo, opts *Options, logger Logger) (*CapacityReport, error) {
+	report := &CapacityReport{}
+	var fileBits func(fileSize int64) int64
+
+	if opts.algorithm == algos.AlgoRobust {
+		carrier, err := newRobustCarrier(pixels, info)
+		if err != nil {
+			return nil, err
+		}
+		carrier.setOriginalSize(int(info.W), int(info.H))
+
+		headerECC, dataECC, err := robustECCConfigs(opts.maxCorrectableErrors)
+		if err != nil {
+			return nil, err
+		}
+
+		report.UsableBits = carrier.cellCount()
+		report.HeaderBits, _ = robustBitsToWrite(headerECC, dataECC, 0, opts.recoveryChunks)
+		fileBits = func(fileSize int64) int64 {
+			_, bits := robustBitsToWrite(headerECC, dataECC, fileSize, opts.recoveryChunks)
+			return bits
+		}
+	} else {
+		mask, err := buildMask(opts.maskRects, opts.maskPath, opts.maskExclude, info, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		channelsPerPix := info.Format.ChannelsPerPix
+		if info.Format.supportsAlpha() && !opts.alpha {
+			channelsPerPix--
+		}
+		bitsPerChannel := uint8(util.Min(int(opts.maxBitsPerChannel), int(info.Format.BitsPerChannel)))
+
+		// Fully-transparent pixels and ones outside of the mask are skipped over
+		supportsAlpha := info.Format.supportsAlpha()
+		alphaChannel := info.Format.alphaChannel()
+		for p := int64(0); p < pixels.count(); p++ {
+			if supportsAlpha && pixels.channel(p, uint8(alphaChannel)) <= 0 {
+				continue
+			}
+			if !mask.allows(p) {
+				continue
+			}
+			report.UsableBits += int64(channelsPerPix) * int64(bitsPerChannel)
+		}
+
+		eccConfig, err := createECCConfig(int(encodeChunkSize), opts.maxCorrectableErrors)
+		if err != nil {
+			return nil, err
+		}
+		headerECC, err := createECCConfig(int(encodeHeaderSize), encodeHeaderErrors)
+		if err != nil {
+			return nil, err
+		}
+
+		report.HeaderBits = int64(chunkCodeLength(headerECC, int(encodeHeaderSize)) * int(opts.HeaderCopies()))
+		// The bits of the ephemeral public key are either taken up by it (in public-key mode) or kept free for it, and
+		// in public-key mode, encryption makes the data a little larger
+		overhead := int64(0)
+		if opts.algorithm == algos.AlgoPattern {
+			report.HeaderBits += int64(keyHintBits)
+		}
+		if len(opts.recipientPath) > 0 {
+			overhead = sealOverhead
+		}
+		fileBits = func(fileSize int64) int64 {
+			bits := int64(0)
+			for _, n := range chunkSizes(fileSize + overhead, opts.recoveryChunks) {
+				bits += int64(chunkCodeLength(eccConfig, n))
+			}
+			return bits
+		}
+	}
+
+	// Binary search for the largest file that fits in the bits left over after the header
+	available := report.UsableBits - report.HeaderBits
+	low, high := int64(0), util.Max64(available / int64(bitsPerByte), 0)
+	for low < high {
+		mid := (low + high + 1) / 2
+		if fileBits(mid) <= available {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	report.MaxFileSize = low
+
+	logger.Log(OutputInfo, fmt.Sprintf("The image can hold a file of up to %d B.", report.MaxFileSize))
+
+	return report, nil
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"image"
 	"os"
+	"io/ioutil"
 	"strconv"
 	"strings"
 
@@ -11,33 +14,120 @@ import (
 	"github.com/zedseven/steg/internal/algos"
 )
 
+// Flag types
+
+// rectList is a repeatable flag that parses rectangles in the format "x0,y0,x1,y1".
+type rectList []image.Rectangle
+
+func (l *rectList) String() string {
+	strs := make([]string, len(*l))
+	for i, r := range *l {
+		strs[i] = fmt.Sprintf("%d,%d,%d,%d", r.Min.X, r.Min.Y, r.Max.X, r.Max.Y)
+	}
+	return strings.Join(strs, ";")
+}
+
+func (l *rectList) Set(value string) error {
+	r, err := steg.ParseRect(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, r)
+	return nil
+}
+
+// stringList is a repeatable flag that collects every value it's given.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ";")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// first returns the first value given, or "" if there are none.
+func (l stringList) first() string {
+	if len(l) <= 0 {
+		return ""
+	}
+	return l[0]
+}
+
+// several returns the values given if there is more than one of them, or nil otherwise.
+func (l stringList) several() []string {
+	if len(l) <= 1 {
+		return nil
+	}
+	return l
+}
+
 // Program entry point
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("You have to specify what you want me to do! The two subcommands are hide and dig.")
+		fmt.Println("You have to specify what you want me to do! The subcommands are hide, dig, capacity, pick, batch, keygen, analyze, bitplane, diff, sanitize and stats.")
+		return
+	}
+
+	switch os.Args[1] {
+	case "keygen":
+		keygen(os.Args[2:])
+		return
+	case "analyze":
+		analyze(os.Args[2:])
+		return
+	case "bitplane":
+		bitplane(os.Args[2:])
+		return
+	case "diff":
+		diff(os.Args[2:])
+		return
+	case "sanitize":
+		sanitize(os.Args[2:])
+		return
+	case "stats":
+		stats(os.Args[2:])
+		return
+	case "batch":
+		batch(os.Args[2:])
 		return
 	}
 
 	var flagSet *flag.FlagSet
 
 	// Flags unique to a command
-	var filePath *string
+	var filePaths, layerFiles, layerConfigs stringList
+	var quality *bool
+	var pickDir *string
 
 	switch os.Args[1] {
 	case "hide":
 		flagSet = flag.NewFlagSet("hide", flag.ExitOnError)
-		filePath = flagSet.String("file", "", "The filepath to the file on disk")
+		flagSet.Var(&filePaths, "file", "The filepath to the file on disk - can be specified multiple times, or be a directory, to hide several files together")
+		flagSet.Var(&layerFiles, "layerfile", "The filepath to a file to hide as an extra layer under a different key, so that digging with one key never reveals the others - can be specified multiple times, from the outside in")
+		flagSet.Var(&layerConfigs, "layerconfig", "The filepath to the options file (see -config) with the key for each -layerfile, in the same order - the key can be a pattern, passphrase or recipient")
+		quality = flagSet.Bool("quality", false, "Whether to measure how much each image was distorted by hiding the file (PSNR, MSE, SSIM and changed channels)")
 	case "dig":
 		flagSet = flag.NewFlagSet("dig", flag.ExitOnError)
+	case "capacity":
+		flagSet = flag.NewFlagSet("capacity", flag.ExitOnError)
+		flagSet.Var(&filePaths, "file", "The filepath to a file (or directory) to check the fit of, after compression - can be specified multiple times (optional)")
+	case "pick":
+		flagSet = flag.NewFlagSet("pick", flag.ExitOnError)
+		flagSet.Var(&filePaths, "file", "The filepath to the file on disk to pick a carrier for - can be specified multiple times, or be a directory, to hide several files together")
+		pickDir = flagSet.String("dir", "", "The filepath to a directory of candidate images (-img can add more)")
 	default:
-		fmt.Println("You have to specify what you want me to do! The two subcommands are hide and dig.")
+		fmt.Println("You have to specify what you want me to do! The subcommands are hide, dig, capacity, pick, batch, keygen, analyze, bitplane, diff, sanitize and stats.")
 		return
 	}
 
 	// Common flags
-	imgPath := flagSet.String("img", "", "The filepath to the image on disk")
-	outPath := flagSet.String("out", "", "The filepath to write the steg image to")
+	var imgPaths, outPaths stringList
+	flagSet.Var(&imgPaths, "img", "The filepath to the image on disk - can be specified multiple times to split a file across several images")
+	flagSet.Var(&outPaths, "out", "The filepath to write the steg image to (once for each -img), or when digging, the file (or directory of files) that was hidden")
 	algoType := flagSet.String("algo", "pattern", "The type of algorithm to use for hiding or digging")
 	patternPath := flagSet.String("pattern", "", "The filepath to the file used for the pattern hash if an algorithm is chosen that requires one")
 	bits := flagSet.Uint("bits", 1, "The number of bits to modify per channel (1-16), at a maximum (working inwards as determined by -msb)")
@@ -45,6 +135,19 @@ func main() {
 	encodeAlpha := flagSet.Bool("alpha", false, "Whether to touch the alpha (transparency) channel")
 	maxCorrectableErrors := flagSet.Uint("errors", 0, "The maximum number of correctable errors to allow for per file chunk")
 	outputLevel := flagSet.String("level", "info", "The output level or verbosity to use")
+	maskPath := flagSet.String("mask", "", "The filepath to a greyscale mask image (same dimensions as the image) where bright areas may be used")
+	var maskRects rectList
+	flagSet.Var(&maskRects, "maskrect", "A rectangle (x0,y0,x1,y1) that may be used - can be specified multiple times")
+	maskExclude := flagSet.Bool("maskexclude", false, "Whether the areas selected by -mask and -maskrect are excluded instead")
+	recoveryChunks := flagSet.Uint("recovery", 0, "The number of recovery chunks to add per 32 file chunks, to rebuild chunks that are too damaged for -errors to correct")
+	threshold := flagSet.Uint("threshold", 0, "When hiding in several images, give each a share of the whole file so that any this many of them can dig it up, instead of splitting it (0 splits it)")
+	headerCopies := flagSet.Uint("headercopies", 1, "The number of copies of the steg header to write, so that a majority vote can be taken when digging - digging reads the number from the image, so it doesn't have to match")
+	interleave := flagSet.Bool("interleave", false, "Whether to spread each file chunk across the whole image to better survive localized damage")
+	compression := flagSet.String("compression", "none", "The algorithm to compress the file with before hiding it (none or deflate)")
+	passphrase := flagSet.String("passphrase", "", "A passphrase to key the algorithm with, instead of -pattern")
+	recipientPath := flagSet.String("recipient", "", "The filepath to the recipient's public key file, to hide for them in public-key mode instead of with -pattern (see keygen)")
+	privateKeyPath := flagSet.String("privatekey", "", "The filepath to your private key file, to dig up a file that was hidden for you in public-key mode")
+	configPath := flagSet.String("config", "", "The filepath to a file of saved options (one key=value per line, with the same keys as these flags) - flags given alongside it take precedence")
 
 	if err := flagSet.Parse(os.Args[2:]); err != nil {
 		fmt.Println("There was an issue parsing the flags!", err.Error())
@@ -64,42 +167,107 @@ func main() {
 		algo = algos.Algo(algoTmp)
 	}
 
+	// Parse out which compression algorithm to use
+	compressionType, ok := steg.StringToCompression(*compression)
+	if !ok {
+		flagSet.PrintDefaults()
+		return
+	}
+
 	// Parse out which output level to use
-	var level steg.OutputLevel
-	levelTmp, err := strconv.ParseInt(*algoType, 10, 8)
-	if err != nil {
-		switch strings.ToLower(*outputLevel) {
-		case "nothing":
-			level = steg.OutputNothing
-		case "steps":
-			level = steg.OutputSteps
-		case "info":
-			level = steg.OutputInfo
-		case "debug":
-			level = steg.OutputDebug
-		default:
-			flagSet.PrintDefaults()
+	level, ok := parseLevel(*outputLevel)
+	if !ok {
+		flagSet.PrintDefaults()
+		return
+	}
+	logger := steg.NewLogger(os.Stdout, level)
+
+	// Build the options, from the config file if there is one, with the flags that were actually set on top
+	opts := steg.NewOptions()
+	if *configPath != "" {
+		text, err := ioutil.ReadFile(*configPath)
+		if err != nil {
+			fmt.Println("There was an issue reading the config file!", err.Error())
+			return
+		}
+		if opts, err = steg.ParseOptions(text); err != nil {
+			fmt.Println("There was an issue parsing the config file!", err.Error())
 			return
 		}
-	} else {
-		level = steg.OutputLevel(levelTmp)
 	}
+	var flagOpts []steg.Option
+	flagSet.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "algo":
+			flagOpts = append(flagOpts, steg.WithAlgorithm(algo))
+		case "pattern":
+			flagOpts = append(flagOpts, steg.WithPatternFile(*patternPath))
+		case "passphrase":
+			flagOpts = append(flagOpts, steg.WithPassphrase(*passphrase))
+		case "recipient":
+			flagOpts = append(flagOpts, steg.WithRecipientKey(*recipientPath))
+		case "privatekey":
+			flagOpts = append(flagOpts, steg.WithPrivateKey(*privateKeyPath))
+		case "bits":
+			flagOpts = append(flagOpts, steg.WithBits(uint8(*bits)))
+		case "msb":
+			flagOpts = append(flagOpts, steg.WithMsb(*msb))
+		case "alpha":
+			flagOpts = append(flagOpts, steg.WithAlpha(*encodeAlpha))
+		case "errors":
+			flagOpts = append(flagOpts, steg.WithECC(uint8(*maxCorrectableErrors)))
+		case "mask":
+			flagOpts = append(flagOpts, steg.WithMaskImage(*maskPath))
+		case "maskrect":
+			flagOpts = append(flagOpts, steg.WithMaskRects(maskRects...))
+		case "maskexclude":
+			flagOpts = append(flagOpts, steg.WithMaskExclude(*maskExclude))
+		case "recovery":
+			flagOpts = append(flagOpts, steg.WithRecoveryChunks(uint8(*recoveryChunks)))
+		case "threshold":
+			flagOpts = append(flagOpts, steg.WithThreshold(uint8(*threshold)))
+		case "headercopies":
+			flagOpts = append(flagOpts, steg.WithHeaderCopies(uint8(*headerCopies)))
+		case "interleave":
+			flagOpts = append(flagOpts, steg.WithInterleave(*interleave))
+		case "compression":
+			flagOpts = append(flagOpts, steg.WithCompression(compressionType))
+		}
+	})
+	opts = opts.With(flagOpts...)
 
 	// Run the appropriate command
 	switch os.Args[1] {
 	case "hide":
 		config := steg.HideConfig{
-			ImagePath:            *imgPath,
-			FilePath:             *filePath,
-			OutPath:              *outPath,
-			PatternPath:          *patternPath,
-			Algorithm:            algo,
-			MaxCorrectableErrors: uint8(*maxCorrectableErrors),
-			MaxBitsPerChannel:    uint8(*bits),
-			EncodeAlpha:          *encodeAlpha,
-			EncodeMsb:            *msb,
-		}
-		if err := steg.Hide(&config, level); err != nil {
+			ImagePath:      imgPaths.first(),
+			FilePaths:      filePaths,
+			OutPath:        outPaths.first(),
+			ImagePaths:     imgPaths.several(),
+			OutPaths:       outPaths.several(),
+			Options:        opts,
+			MeasureQuality: *quality,
+		}
+		if len(layerConfigs) != len(layerFiles) {
+			fmt.Println("Every -layerfile needs a -layerconfig with its key.")
+			flagSet.PrintDefaults()
+			return
+		}
+		for i, layerFile := range layerFiles {
+			text, err := ioutil.ReadFile(layerConfigs[i])
+			if err != nil {
+				fmt.Println("There was an issue reading the layer config file!", err.Error())
+				return
+			}
+			layerOpts, err := steg.ParseOptions(text)
+			if err != nil {
+				fmt.Println("There was an issue parsing the layer config file!", err.Error())
+				return
+			}
+			config.Layers = append(config.Layers, steg.HideLayer{FilePath: layerFile, Options: layerOpts})
+		}
+		report, err := steg.Hide(&config, logger)
+		if err != nil {
 			fmt.Println(err.Error())
 			switch err.(type) {
 			case *steg.InvalidFormatError:
@@ -108,18 +276,17 @@ func main() {
 			}
 			return
 		}
+		for _, q := range report.Quality {
+			printQuality(q)
+		}
 	case "dig":
 		config := steg.DigConfig{
-			ImagePath:         *imgPath,
-			OutPath:           *outPath,
-			PatternPath:       *patternPath,
-			Algorithm:         algo,
-			MaxCorrectableErrors: uint8(*maxCorrectableErrors),
-			MaxBitsPerChannel: uint8(*bits),
-			DecodeAlpha:       *encodeAlpha,
-			DecodeMsb:         *msb,
-		}
-		if err := steg.Dig(&config, level); err != nil {
+			ImagePath:  imgPaths.first(),
+			ImagePaths: imgPaths.several(),
+			OutPath:    outPaths.first(),
+			Options:    opts,
+		}
+		if err := steg.Dig(&config, logger); err != nil {
 			fmt.Println(err.Error())
 			switch err.(type) {
 			case *steg.InvalidFormatError:
@@ -128,9 +295,450 @@ func main() {
 			}
 			return
 		}
+	case "capacity":
+		config := steg.HideConfig{
+			ImagePath:  imgPaths.first(),
+			FilePaths:  filePaths,
+			ImagePaths: imgPaths.several(),
+			Options:    opts,
+		}
+		report, err := steg.Capacity(&config, logger)
+		if err != nil {
+			fmt.Println(err.Error())
+			switch err.(type) {
+			case *steg.InvalidFormatError:
+				flagSet.PrintDefaults()
+				return
+			}
+			return
+		}
+		fmt.Printf("Usable bits: %d\nHeader bits: %d\nMaximum file size: %d B\n",
+			report.UsableBits, report.HeaderBits, report.MaxFileSize)
+		if len(filePaths) > 0 {
+			fmt.Printf("File size: %d B\nStored size: %d B\nFits: %v\n", report.FileSize, report.StoredSize, report.Fits)
+		}
+	case "pick":
+		config := steg.PickConfig{
+			Dir:        *pickDir,
+			ImagePaths: imgPaths,
+			FilePaths:  filePaths,
+			Options:    opts,
+		}
+		scores, err := steg.PickCarriers(&config, logger)
+		if err != nil {
+			fmt.Println(err.Error())
+			switch err.(type) {
+			case *steg.InvalidFormatError:
+				flagSet.PrintDefaults()
+				return
+			}
+			return
+		}
+		fmt.Println("Candidates from the best to the worst (score, fill, embedding rate, texture, clean rate, detectability):")
+		for _, score := range scores {
+			fits := ""
+			if !score.Capacity.Fits {
+				fits = " (doesn't fit)"
+			}
+			fmt.Printf("%.4f %6.2f%% %6.2f%% %.4f %6.2f%% %.4f %v%v\n", score.Score, 100 * score.Fill, 100 * score.Rate,
+				score.Texture, 100 * score.CleanRate, score.Detectability, score.ImagePath, fits)
+		}
+
+		// With -out, the file is hidden in the best candidate right away
+		if len(outPaths) <= 0 {
+			return
+		}
+		if !scores[0].Capacity.Fits {
+			fmt.Println("The file doesn't fit in any of the candidates!")
+			return
+		}
+		fmt.Printf("Hiding the file in '%v'...\n", scores[0].ImagePath)
+		hideConfig := steg.HideConfig{
+			ImagePath: scores[0].ImagePath,
+			FilePaths: filePaths,
+			OutPath:   outPaths.first(),
+			Options:   opts,
+		}
+		if _, err = steg.Hide(&hideConfig, logger); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	default:
+		fmt.Println("You have to specify what you want me to do! The subcommands are hide, dig, capacity, pick, batch, keygen, analyze, bitplane, diff, sanitize and stats.")
+		return
+	}
+}
+
+
+// batch runs the hide and dig jobs of a manifest.
+func batch(args []string) {
+	flagSet := flag.NewFlagSet("batch", flag.ExitOnError)
+	manifestPath := flagSet.String("manifest", "", "The filepath to the manifest of jobs (JSON, or CSV with a .csv extension)")
+	resultsPath := flagSet.String("results", "", "The filepath to write the result of every job to (JSON, or CSV with a .csv extension) (optional)")
+	workers := flagSet.Uint("workers", 0, "The number of jobs to run at once (0 for the number of CPUs)")
+	outputLevel := flagSet.String("level", "steps", "The output level or verbosity to use")
+	if err := flagSet.Parse(args); err != nil {
+		fmt.Println("There was an issue parsing the flags!", err.Error())
+		flagSet.PrintDefaults()
+	}
+
+	level, ok := parseLevel(*outputLevel)
+	if !ok || *manifestPath == "" {
+		flagSet.PrintDefaults()
+		return
+	}
+
+	jobs, err := steg.ReadManifest(*manifestPath)
+	if err != nil {
+		fmt.Println("There was an issue reading the manifest!", err.Error())
+		return
+	}
+	results := steg.RunBatch(context.Background(), jobs, int(*workers), steg.NewLogger(os.Stdout, level))
+
+	failed := 0
+	for _, r := range results {
+		if !r.OK {
+			failed++
+		}
+	}
+	fmt.Printf("%d of %d job(s) succeeded, and %d failed.\n", len(results) - failed, len(results), failed)
+	if *resultsPath != "" {
+		if err = steg.WriteBatchResults(*resultsPath, results); err != nil {
+			fmt.Println("There was an issue writing the results!", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote the results to '%v'.\n", *resultsPath)
+	}
+	// Scripts running a batch need to be able to tell that some of it failed
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// keygen generates a key pair for public-key mode, writing the private key to the path given by -out and the public
+// key alongside it, with ".pub" added.
+func keygen(args []string) {
+	flagSet := flag.NewFlagSet("keygen", flag.ExitOnError)
+	outPath := flagSet.String("out", "", "The filepath to write the private key file to - the public key file is written alongside it, with .pub added")
+	if err := flagSet.Parse(args); err != nil {
+		fmt.Println("There was an issue parsing the flags!", err.Error())
+		flagSet.PrintDefaults()
+	}
+	if *outPath == "" {
+		flagSet.PrintDefaults()
+		return
+	}
+
+	publicKey, privateKey, err := steg.GenerateKeyPair()
+	if err != nil {
+		fmt.Println("There was an issue generating the key pair!", err.Error())
+		return
+	}
+	if err = ioutil.WriteFile(*outPath, steg.MarshalKey(privateKey), 0600); err != nil {
+		fmt.Println("There was an issue writing the private key file!", err.Error())
+		return
+	}
+	if err = ioutil.WriteFile(*outPath + ".pub", steg.MarshalKey(publicKey), 0644); err != nil {
+		fmt.Println("There was an issue writing the public key file!", err.Error())
+		return
+	}
+	fmt.Printf("Wrote the private key to '%v' and the public key to '%v'. Give the public key to whoever will hide " +
+		"files for you, and keep the private key to yourself.\n", *outPath, *outPath + ".pub")
+}
+
+// analyze runs one of the steganalysis attacks against an image and prints the results.
+func analyze(args []string) {
+	if len(args) < 1 {
+		fmt.Println("You have to specify which analysis to run! The analyses are chi2, rs and spa.")
+		return
+	}
+
+	flagSet := flag.NewFlagSet("analyze " + args[0], flag.ExitOnError)
+	imgPath := flagSet.String("img", "", "The filepath to the image on disk")
+	window := flagSet.Int64("window", 0, "The number of channel values in each window of the image that is analyzed on its own (0 for 1% of the image)")
+	step := flagSet.Int64("step", 0, "The number of channel values between the starts of consecutive windows (0 for the same as -window)")
+	plotPath := flagSet.String("plot", "", "The filepath to render the probability curve to as a PNG (optional)")
+	outputLevel := flagSet.String("level", "steps", "The output level or verbosity to use")
+	if err := flagSet.Parse(args[1:]); err != nil {
+		fmt.Println("There was an issue parsing the flags!", err.Error())
+		flagSet.PrintDefaults()
+	}
+
+	level, ok := parseLevel(*outputLevel)
+	if !ok {
+		flagSet.PrintDefaults()
+		return
+	}
+	logger := steg.NewLogger(os.Stdout, level)
+	config := steg.AnalyzeConfig{
+		ImagePath: *imgPath,
+		Window:    *window,
+		Step:      *step,
+	}
+
+	switch args[0] {
+	case "chi2":
+		report, err := steg.ChiSquareAttack(&config, logger)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		fmt.Printf("Samples: %d\nChi-square: %.2f\nEmbedding probability: %.4f\n", report.Samples, report.ChiSquare,
+			report.Probability)
+		fmt.Println("Probability curve (channel values: probability):")
+		curve := make([]float64, len(report.Windows))
+		for i, w := range report.Windows {
+			curve[i] = w.Probability
+			fmt.Printf("%10d-%-10d %.4f %v\n", w.Start, w.End, w.Probability,
+				strings.Repeat("#", int(w.Probability * 40 + 0.5)))
+		}
+		if *plotPath != "" {
+			if err = steg.PlotCurve(curve, *plotPath); err != nil {
+				fmt.Println("There was an issue writing the plot!", err.Error())
+				return
+			}
+			fmt.Printf("Wrote the plot to '%v'.\n", *plotPath)
+		}
+	case "rs", "spa":
+		img, err := decodeImage(*imgPath)
+		if err != nil {
+			fmt.Println("There was an issue loading the image!", err.Error())
+			return
+		}
+		var estimates []steg.ChannelEstimate
+		if args[0] == "rs" {
+			logger.Log(steg.OutputSteps, "Running RS analysis...")
+			estimates, err = steg.RSAnalysis(img)
+		} else {
+			logger.Log(steg.OutputSteps, "Running Sample Pair Analysis...")
+			estimates, err = steg.SamplePairAnalysis(img)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		fmt.Println("Estimated embedding rate per channel (channel: rate, fraction of LSBs modified):")
+		for _, e := range estimates {
+			fmt.Printf("%-8v %6.2f%% %6.2f%%\n", e.Channel, 100 * e.Rate, 100 * e.Modified)
+		}
 	default:
-		fmt.Println("You have to specify what you want me to do! Either hide or dig.")
+		fmt.Println("You have to specify which analysis to run! The analyses are chi2, rs and spa.")
+		return
+	}
+}
+
+// decodeImage opens and decodes the image at imgPath.
+func decodeImage(imgPath string) (image.Image, error) {
+	if len(imgPath) <= 0 {
+		return nil, fmt.Errorf("no image was specified")
+	}
+	f, err := os.Open(imgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// bitplane renders bit planes of an image.
+func bitplane(args []string) {
+	flagSet := flag.NewFlagSet("bitplane", flag.ExitOnError)
+	imgPath := flagSet.String("img", "", "The filepath to the image on disk")
+	outPath := flagSet.String("out", "", "The filepath to write the rendered bit plane to as a PNG")
+	channels := flagSet.String("channel", "", "The channels to render, by their letters (r, g, b and a, c, m, y and k for CMYK, or g for greyscale - e.g. r or rgb) - combined with XOR (default every channel but alpha)")
+	bits := flagSet.String("bit", "0", "The bits to render, from 0 for the least-significant bit, separated by commas - combined with XOR")
+	all := flagSet.Bool("all", false, "Whether to render a contact sheet of every bit of every channel instead (one row per channel)")
+	outputLevel := flagSet.String("level", "steps", "The output level or verbosity to use")
+	if err := flagSet.Parse(args); err != nil {
+		fmt.Println("There was an issue parsing the flags!", err.Error())
+		flagSet.PrintDefaults()
+	}
+
+	level, ok := parseLevel(*outputLevel)
+	if !ok {
+		flagSet.PrintDefaults()
 		return
 	}
+	config := steg.BitPlaneConfig{
+		ImagePath: *imgPath,
+		OutPath:   *outPath,
+		Channels:  *channels,
+		All:       *all,
+	}
+	for _, str := range strings.Split(*bits, ",") {
+		b, err := strconv.ParseUint(strings.TrimSpace(str), 10, 8)
+		if err != nil {
+			fmt.Printf("'%v' is not a valid bit!\n", str)
+			flagSet.PrintDefaults()
+			return
+		}
+		config.Bits = append(config.Bits, uint8(b))
+	}
+
+	if err := steg.BitPlane(&config, steg.NewLogger(os.Stdout, level)); err != nil {
+		fmt.Println(err.Error())
+	}
 }
 
+// diff compares two images and prints how different they are.
+func diff(args []string) {
+	flagSet := flag.NewFlagSet("diff", flag.ExitOnError)
+	aPath := flagSet.String("a", "", "The filepath to the original image on disk")
+	bPath := flagSet.String("b", "", "The filepath to the modified image on disk")
+	heatmapPath := flagSet.String("heatmap", "", "The filepath to render a heatmap of where the images differ to as a PNG (optional)")
+	overlay := flagSet.Bool("overlay", false, "Whether to draw the heatmap over a dimmed copy of the original image")
+	outputLevel := flagSet.String("level", "steps", "The output level or verbosity to use")
+	if err := flagSet.Parse(args); err != nil {
+		fmt.Println("There was an issue parsing the flags!", err.Error())
+		flagSet.PrintDefaults()
+	}
+
+	level, ok := parseLevel(*outputLevel)
+	if !ok {
+		flagSet.PrintDefaults()
+		return
+	}
+
+	logger := steg.NewLogger(os.Stdout, level)
+	report, err := steg.CompareImages(*aPath, *bPath, logger)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	printQuality(report)
+
+	if *heatmapPath != "" {
+		config := steg.HeatmapConfig{
+			OriginalPath: *aPath,
+			ModifiedPath: *bPath,
+			OutPath:      *heatmapPath,
+			Overlay:      *overlay,
+		}
+		if err = steg.RenderHeatmap(&config, logger); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		fmt.Printf("Wrote the heatmap to '%v'.\n", *heatmapPath)
+	}
+}
+
+// sanitize overwrites the low bits of an image and scrubs its cells to destroy anything that might be hidden in it.
+// Data hidden with -msb or with more -bits, or with -algo=robust in an image that was resized since, isn't destroyed.
+func sanitize(args []string) {
+	flagSet := flag.NewFlagSet("sanitize", flag.ExitOnError)
+	imgPath := flagSet.String("img", "", "The filepath to the image on disk")
+	outPath := flagSet.String("out", "", "The filepath to write the sanitized image to")
+	bits := flagSet.Uint("bits", 1, "The number of least-significant bits to overwrite per channel (1-16) - destroys anything hidden with -bits up to this, but not with -msb")
+	zero := flagSet.Bool("zero", false, "Whether to set the bits to 0 instead of to random values")
+	noise := flagSet.Uint("noise", 0, "The largest amount to randomly shift every channel value by, up or down, before overwriting the bits")
+	keepAlpha := flagSet.Bool("keepalpha", false, "Whether to leave the alpha (transparency) channel as it is - leaves anything hidden with -alpha")
+	keepCells := flagSet.Bool("keepcells", false, "Whether to leave the DCT coefficients of the 8x8px cells as they are - leaves anything hidden with -algo=robust")
+	outputLevel := flagSet.String("level", "steps", "The output level or verbosity to use")
+	if err := flagSet.Parse(args); err != nil {
+		fmt.Println("There was an issue parsing the flags!", err.Error())
+		flagSet.PrintDefaults()
+	}
+
+	level, ok := parseLevel(*outputLevel)
+	if !ok || *bits > 16 || *noise > 0xffff {
+		flagSet.PrintDefaults()
+		return
+	}
+	config := steg.SanitizeConfig{
+		ImagePath: *imgPath,
+		OutPath:   *outPath,
+		Bits:      uint8(*bits),
+		Zero:      *zero,
+		Noise:     uint16(*noise),
+		KeepAlpha: *keepAlpha,
+		KeepCells: *keepCells,
+	}
+
+	report, err := steg.Sanitize(&config, steg.NewLogger(os.Stdout, level))
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	printQuality(report)
+}
+
+// stats prints the statistical fingerprint of an image.
+func stats(args []string) {
+	flagSet := flag.NewFlagSet("stats", flag.ExitOnError)
+	imgPath := flagSet.String("img", "", "The filepath to the image on disk")
+	bins := flagSet.Uint("bins", 16, "The number of bins to group each histogram into (0 to leave the histograms out)")
+	outputLevel := flagSet.String("level", "steps", "The output level or verbosity to use")
+	if err := flagSet.Parse(args); err != nil {
+		fmt.Println("There was an issue parsing the flags!", err.Error())
+		flagSet.PrintDefaults()
+	}
+
+	level, ok := parseLevel(*outputLevel)
+	if !ok {
+		flagSet.PrintDefaults()
+		return
+	}
+
+	report, err := steg.Stats(*imgPath, steg.NewLogger(os.Stdout, level))
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("Pixels: %d\nTransparent pixels: %d (%.2f%%)\n", report.Pixels, report.TransparentPixels,
+		100 * float64(report.TransparentPixels) / float64(report.Pixels))
+	for _, c := range report.Channels {
+		fmt.Printf("\n%v:\n", c.Channel)
+		fmt.Printf("\tRange: %d-%d\n\tMean: %.2f\n\tStandard deviation: %.2f\n\tDistinct values: %d\n", c.Min, c.Max,
+			c.Mean, c.StdDev, c.Distinct)
+		fmt.Printf("\tSaturated: %.2f%%\n\tLSB ratio: %.4f\n\tPair asymmetry: %.4f\n\tTexture: %.4f\n",
+			100 * c.Saturated, c.LSBRatio, c.PairAsymmetry, c.Texture)
+		if *bins <= 0 {
+			continue
+		}
+
+		// Group the histogram into bins, and draw each one as a bar relative to the largest
+		width := (len(c.Histogram) + int(*bins) - 1) / int(*bins)
+		counts := make([]int64, (len(c.Histogram) + width - 1) / width)
+		largest := int64(0)
+		for v, count := range c.Histogram {
+			counts[v / width] += count
+			if counts[v / width] > largest {
+				largest = counts[v / width]
+			}
+		}
+		for i, count := range counts {
+			bar := 0
+			if largest > 0 {
+				bar = int(float64(count) / float64(largest) * 40 + 0.5)
+			}
+			fmt.Printf("\t%5d-%-5d %10d %v\n", i * width, (i + 1) * width - 1, count, strings.Repeat("#", bar))
+		}
+	}
+}
+
+// printQuality prints a quality report.
+func printQuality(report *steg.QualityReport) {
+	fmt.Printf("PSNR: %.2f dB\nMSE: %.6f\nSSIM: %.6f\nChanged channels: %d of %d (%.2f%%)\nMax channel delta: %d\n",
+		report.PSNR, report.MSE, report.SSIM, report.ChangedChannels, report.Channels,
+		100 * float64(report.ChangedChannels) / float64(report.Channels), report.MaxDelta)
+}
+
+// parseLevel parses an output level, either by name or by number.
+func parseLevel(str string) (steg.OutputLevel, bool) {
+	if n, err := strconv.ParseInt(str, 10, 8); err == nil {
+		return steg.OutputLevel(n), true
+	}
+	switch strings.ToLower(str) {
+	case "nothing":
+		return steg.OutputNothing, true
+	case "steps":
+		return steg.OutputSteps, true
+	case "info":
+		return steg.OutputInfo, true
+	case "debug":
+		return steg.OutputDebug, true
+	default:
+		return steg.OutputNothing, false
+	}
+}
@@ -0,0 +1,176 @@
+package steg
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/zedseven/steg/internal/util"
+)
+
+// Compression is applied to the file before ECC and hiding, so that compressible files (such as text, JSON and logs)
+// take up far less of the image. The algorithm used is recorded in the layout settings of the steg header, so Dig
+// decompresses the file without being told to.
+//
+// Compressed data starts with the size of the file once it's decompressed, and decompression stops there, so that a
+// small payload can never decompress into something huge (a decompression bomb).
+
+const (
+	// compressedSizeBytes is the size in bytes of the decompressed size that compressed data starts with.
+	compressedSizeBytes int   = 4
+	// maxDeflateRatio is the most that DEFLATE can compress data by, so any larger decompressed size is made up.
+	maxDeflateRatio     int64 = 1032
+)
+
+// Types
+
+// Compression is used to define the compression algorithms supported by the package.
+type Compression uint8
+
+const (
+	// CompressionNone stores the file as-is.
+	CompressionNone    Compression = iota
+	// CompressionDeflate compresses the file with DEFLATE (RFC 1951) at the best compression level.
+	CompressionDeflate Compression = iota
+	// maxCompressionVal is the maximum compression value, used exclusively for validity checking.
+	maxCompressionVal  Compression = iota - 1
+)
+
+// IsValid simply determines whether a given compression algorithm is valid.
+func (c Compression) IsValid() bool {
+	return c <= maxCompressionVal
+}
+
+// String returns the name of the compression algorithm, or "<unknown>" if unknown.
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionDeflate:
+		return "deflate"
+	default:
+		return "<unknown>"
+	}
+}
+
+// StringToCompression simply parses a string into a compression algorithm. ok is false if the string is not
+// recognized.
+func StringToCompression(str string) (c Compression, ok bool) {
+	switch strings.ToLower(str) {
+	case "none", "":
+		return CompressionNone, true
+	case "deflate":
+		return CompressionDeflate, true
+	default:
+		return CompressionNone, false
+	}
+}
+
+// Helper functions
+
+// compressData compresses data with c.
+func compressData(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return data, nil
+	case CompressionDeflate:
+		if int64(len(data)) > math.MaxUint32 {
+			return nil, &InvalidFormatError{fmt.Sprintf("The file is too large to compress (%d B).", len(data))}
+		}
+		var b bytes.Buffer
+		size := make([]byte, compressedSizeBytes)
+		binary.BigEndian.PutUint32(size, uint32(len(data)))
+		b.Write(size)
+		w, err := flate.NewWriter(&b, flate.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(data); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	default:
+		return nil, &InvalidFormatError{fmt.Sprintf("The compression algorithm (%d) does not exist.", c)}
+	}
+}
+
+// decompressData reverses compressData. Since the data has already passed ECC by this point, a failure means the image
+// was too damaged to recover the file, or the wrong key was used.
+func decompressData(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return data, nil
+	case CompressionDeflate:
+		if len(data) < compressedSizeBytes {
+			return nil, &InvalidFormatError{"The hidden file could not be decompressed: it's too short."}
+		}
+		size := int64(binary.BigEndian.Uint32(data[:compressedSizeBytes]))
+		if size > int64(len(data) - compressedSizeBytes) * maxDeflateRatio {
+			return nil, &InvalidFormatError{fmt.Sprintf("The hidden file could not be decompressed: %d B can't " +
+				"decompress to %d B.", len(data) - compressedSizeBytes, size)}
+		}
+		r := flate.NewReader(bytes.NewReader(data[compressedSizeBytes:]))
+		// One byte more than the size is allowed through, to tell whether there's more than there should be
+		out, err := ioutil.ReadAll(io.LimitReader(r, size + 1))
+		if err != nil {
+			return nil, &InvalidFormatError{fmt.Sprintf("The hidden file could not be decompressed: %v", err.Error())}
+		}
+		if int64(len(out)) > size {
+			return nil, &InvalidFormatError{fmt.Sprintf("The hidden file could not be decompressed: it decompresses " +
+				"to more than the %d B that it should.", size)}
+		}
+		if int64(len(out)) < size {
+			return nil, &InvalidFormatError{fmt.Sprintf("The hidden file could not be decompressed: it decompresses " +
+				"to %d B, but it should be %d B.", len(out), size)}
+		}
+		return out, r.Close()
+	default:
+		return nil, &BadHeaderError{}
+	}
+}
+
+// preparePayload compresses the file data according to opts. If compression doesn't make the data any smaller, it's
+// stored as-is instead, and the returned Options say so, so that the steg header describes what was actually stored.
+func preparePayload(data []byte, opts *Options, logger Logger) ([]byte, *Options, error) {
+	if opts.compression == CompressionNone {
+		return data, opts, nil
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Compressing the file with %v...", opts.compression))
+	compressed, err := compressData(opts.compression, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(compressed) >= len(data) {
+		logger.Log(OutputInfo, fmt.Sprintf("Compression didn't make the file any smaller (%d B to %d B), so it " +
+			"will be stored as-is.", len(data), len(compressed)))
+		return data, opts.With(WithCompression(CompressionNone)), nil
+	}
+	logger.Log(OutputInfo, fmt.Sprintf("Compressed the file from %d B to %d B (%2.2f%%).", len(data), len(compressed),
+		100 * float64(len(compressed)) / float64(util.Max(len(data), 1))))
+	return compressed, opts, nil
+}
+
+// unpackPayload joins the file chunks that were dug up and reverses the compression recorded in opts.
+func unpackPayload(chunks [][]byte, opts *Options, logger Logger) ([]byte, error) {
+	data := bytes.Join(chunks, nil)
+	if opts.compression == CompressionNone {
+		return data, nil
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Decompressing the file with %v...", opts.compression))
+	out, err := decompressData(opts.compression, data)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log(OutputInfo, fmt.Sprintf("Decompressed the file from %d B to %d B.", len(data), len(out)))
+	return out, nil
+}
@@ -0,0 +1,152 @@
+package steg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Tests
+
+func TestCompressRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	r.Read(random)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"one byte", []byte{42}},
+		{"text", []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100))},
+		{"random", random},
+		// As compressible as DEFLATE gets, which has to stay within maxDeflateRatio
+		{"zeros", make([]byte, 1 << 20)},
+	}
+	for _, test := range tests {
+		for _, c := range []Compression{CompressionNone, CompressionDeflate} {
+			compressed, err := compressData(c, test.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decompressData(c, compressed)
+			if err != nil {
+				t.Fatalf("%v, %v: %v", test.name, c, err)
+			}
+			if !bytes.Equal(got, test.data) {
+				t.Errorf("%v, %v: the data didn't come back the same.", test.name, c)
+			}
+		}
+	}
+}
+
+func TestPreparePayload(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	r.Read(random)
+	text := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100))
+
+	tests := []struct {
+		name string
+		data []byte
+		want Compression
+	}{
+		{"empty", nil, CompressionNone},
+		{"random", random, CompressionNone},
+		{"text", text, CompressionDeflate},
+	}
+	for _, test := range tests {
+		opts := NewOptions(WithCompression(CompressionDeflate))
+		payload, payloadOpts, err := preparePayload(test.data, opts, loggerOrNop(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payloadOpts.compression != test.want {
+			t.Errorf("%v: stored with %v, want %v", test.name, payloadOpts.compression, test.want)
+		}
+		if test.want == CompressionNone && !bytes.Equal(payload, test.data) {
+			t.Errorf("%v: the data wasn't stored as-is.", test.name)
+		}
+		if test.want != CompressionNone && len(payload) >= len(test.data) {
+			t.Errorf("%v: compressed from %d B to %d B", test.name, len(test.data), len(payload))
+		}
+		if opts.compression != CompressionDeflate {
+			t.Errorf("%v: the Options passed in were changed.", test.name)
+		}
+
+		got, err := unpackPayload([][]byte{payload}, payloadOpts, loggerOrNop(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, test.data) {
+			t.Errorf("%v: the data didn't come back the same.", test.name)
+		}
+	}
+}
+
+func TestDecompressMalformed(t *testing.T) {
+	text := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100))
+	valid, err := compressData(CompressionDeflate, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamSize := int64(len(valid) - compressedSizeBytes)
+	// The first block of the stream claims to be of the reserved type
+	corrupted := append([]byte(nil), valid...)
+	corrupted[compressedSizeBytes] = 0xff
+
+	// A bomb decompresses to far more than its size prefix says, which has to be cut off at the size
+	bomb, err := compressData(CompressionDeflate, make([]byte, 1 << 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		// overRatio is whether the size is rejected before anything is decompressed
+		overRatio bool
+	}{
+		{"no bytes", nil, false},
+		{"truncated size", valid[:compressedSizeBytes - 1], false},
+		{"no stream", valid[:compressedSizeBytes], true},
+		{"truncated stream", valid[:len(valid) - 1], false},
+		{"corrupted stream", corrupted, false},
+		{"size too small", withSizePrefix(valid, int64(len(text)) - 1), false},
+		{"size too large", withSizePrefix(valid, int64(len(text)) + 1), false},
+		{"size at the ratio limit", withSizePrefix(valid, streamSize * maxDeflateRatio), false},
+		{"size over the ratio limit", withSizePrefix(valid, streamSize * maxDeflateRatio + 1), true},
+		{"size far over the ratio limit", withSizePrefix(valid, 1 << 32 - 1), true},
+		{"bomb with a small size", withSizePrefix(bomb, 1000), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decompressData(CompressionDeflate, test.data)
+			if err == nil {
+				t.Fatalf("Malformed data was decompressed into %d B.", len(got))
+			}
+			if _, ok := err.(*InvalidFormatError); !ok {
+				t.Fatalf("got a %T, want an *InvalidFormatError: %v", err, err)
+			}
+			if overRatio := strings.Contains(err.Error(), "can't decompress"); overRatio != test.overRatio {
+				t.Fatalf("Rejected for being over the ratio limit: %v, want %v (%v)", overRatio, test.overRatio, err)
+			}
+		})
+	}
+
+	if _, err = decompressData(maxCompressionVal + 1, valid); err == nil {
+		t.Error("Data was decompressed with an unknown compression algorithm.")
+	}
+}
+
+// Helper functions
+
+// withSizePrefix returns a copy of compressed data with the decompressed size replaced with size.
+func withSizePrefix(data []byte, size int64) []byte {
+	forged := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(forged[:compressedSizeBytes], uint32(size))
+	return forged
+}
@@ -1,8 +1,10 @@
 package steg
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"os"
+	"math"
 
 	"github.com/zedseven/bch"
 	"github.com/zedseven/binmani"
@@ -15,22 +17,17 @@ import (
 // DigConfig stores the configuration options for the Dig operation.
 type DigConfig struct {
 	// ImagePath is the path on disk to a supported image.
-	ImagePath         string
-	// OutPath is the path on disk to write the output image.
-	OutPath           string
-	// PatternPath is the path on disk to the pattern file used in decoding.
-	PatternPath       string
-	// Algorithm is the algorithm to use in the operation.
-	Algorithm         algos.Algo
-	// MaxCorrectableErrors is the number of bit errors to be able to correct for per file chunk. Setting it to 0 disables bit ECC.
-	MaxCorrectableErrors uint8
-	// MaxBitsPerChannel is the maximum number of bits to write per pixel channel.
-	// The minimum of this and the supported max of the image format is used.
-	MaxBitsPerChannel uint8
-	// DecodeAlpha is whether or not to decode the alpha channel.
-	DecodeAlpha       bool
-	// DecodeMsb is whether to decode the most-significant bits instead - mostly for debugging.
-	DecodeMsb         bool
+	ImagePath  string
+	// ImagePaths is a list of paths on disk to images that a file was split or shared across, in any order.
+	// If it's set, ImagePath is ignored.
+	ImagePaths []string
+	// OutPath is the path on disk to write the output file. If several files were hidden together, it's the directory to
+	// extract them into instead.
+	OutPath    string
+	// Options are the settings the file was hidden with. If nil, the defaults from NewOptions are used.
+	// The layout settings (ECC, interleaving, recovery chunks and compression) are read from the steg header instead, so
+	// they don't need to match.
+	Options    *Options
 }
 
 // BadHeaderError is thrown when the read header is garbage. Likely caused by a bad configuration or source image.
@@ -45,111 +42,231 @@ func (e *BadHeaderError) Error() string {
 
 // Dig extracts the binary data of a file from a provided image on disk, and saves the result to a new file.
 // The configuration must perfectly match the one used in encoding in order to extract successfully.
-func Dig(config *DigConfig, outputLevel OutputLevel) error {
+func Dig(config *DigConfig, logger Logger) error {
+	return DigContext(context.Background(), config, logger, nil)
+}
+
+// DigContext is Dig, but it stops between file chunks once ctx is cancelled, returning the error of ctx. If progress
+// is not nil, it is called as the file chunks are processed.
+func DigContext(ctx context.Context, config *DigConfig, logger Logger, progress ProgressFunc) error {
+	logger = loggerOrNop(logger)
+
 	// Input validation
-	if len(config.ImagePath) <= 0 {
-		return &InvalidFormatError{"ImagePath is empty."}
+	imagePaths := config.ImagePaths
+	if len(imagePaths) <= 0 {
+		if len(config.ImagePath) <= 0 {
+			return &InvalidFormatError{"ImagePath is empty."}
+		}
+		imagePaths = []string{config.ImagePath}
 	}
 	if len(config.OutPath) <= 0 {
 		return &InvalidFormatError{"OutPath is empty."}
 	}
-	if len(config.PatternPath) <= 0 {
-		return &InvalidFormatError{"PatternPath is empty."}
+	opts := config.Options
+	if opts == nil {
+		opts = NewOptions()
 	}
-	if !config.Algorithm.IsValid() {
-		return &InvalidFormatError{"Algorithm is invalid."}
+	if err := opts.Validate(); err != nil {
+		return err
 	}
-	if config.MaxBitsPerChannel < 0 || config.MaxBitsPerChannel > 16 {
-		return &InvalidFormatError{fmt.Sprintf("MaxBitsPerChannel is outside the allowed range of 0-16: Provided %d.", config.MaxBitsPerChannel)}
+	if len(opts.recipientPath) > 0 && len(opts.privateKeyPath) <= 0 {
+		return &InvalidFormatError{"Digging in public-key mode requires the private key, not the recipient's public key."}
 	}
 
-	printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Steg v%d.%d.%d by Zacchary Dempsey-Plante.", VersionMax, VersionMid, VersionMin))
-	printlnLvl(outputLevel, OutputDebug, "This tool has been set to display debug output.")
+	logger.Log(OutputSteps, fmt.Sprintf("Steg v%d.%d.%d by Zacchary Dempsey-Plante.", VersionMax, VersionMid, VersionMin))
+	logger.Log(OutputDebug, "This tool has been set to display debug output.")
 
-	printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Loading the image from '%v'...", config.ImagePath))
-	pixels, info, err := loadImage(config.ImagePath, outputLevel)
+	tracker := newProgressTracker(ctx, progress)
+
+	logger.Log(OutputSteps, "Loading up the pattern key...")
+	pHash, err := opts.patternHash()
 	if err != nil {
-		printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", config.ImagePath))
+		logger.Log(OutputSteps, "Something went wrong while attempting to load the pattern key.")
 		return err
 	}
+	logger.Log(OutputInfo, "Loaded the pattern key.", "hash", pHash)
+
 
-	config.MaxBitsPerChannel = uint8(util.Min(int(config.MaxBitsPerChannel), int(info.Format.BitsPerChannel)))
+	parts := make([]dugPart, len(imagePaths))
+	for i, imagePath := range imagePaths {
+		part, err := digFromImage(tracker, imagePath, opts, pHash, logger)
+		if err != nil {
+			return err
+		}
+		parts[i] = *part
+	}
+
+	data, err := joinParts(parts)
+	if err != nil {
+		return err
+	}
+	if len(parts) > 1 {
+		eccErrors := 0
+		for _, p := range parts {
+			eccErrors += p.eccErrors
+		}
+		logger.Log(OutputInfo, fmt.Sprintf("Joined the %d parts of the file, with %d error(s) between them.", len(parts),
+			eccErrors), "set", parts[0].part.setID)
+	}
+	opts = parts[0].opts
+
+	if data, err = unpackPayload([][]byte{data}, opts, logger); err != nil {
+		return err
+	}
+
+	if err = writePayload(data, opts.archive, config.OutPath, logger); err != nil {
+		return err
+	}
+
+
+	logger.Log(OutputSteps, "All done! c:")
+
+	return nil
+}
+
+// Helper functions
+
+// digFromImage digs up the part of a file that is hidden in the image at imagePath.
+func digFromImage(tracker *progressTracker, imagePath string, opts *Options, pHash int64, logger Logger) (*dugPart, error) {
+	logger.Log(OutputSteps, fmt.Sprintf("Loading the image from '%v'...", imagePath))
+	pixels, info, err := loadImage(imagePath, logger)
+	if err != nil {
+		logger.Log(OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", imagePath))
+		return nil, err
+	}
 
-	printlnLvl(outputLevel, OutputInfo,
+	return digFromPixels(tracker, imagePath, pixels, info, opts, pHash, logger)
+}
+
+// digFromPixels digs up the part of a file that is hidden in the pixels of a loaded image. imagePath is only used to
+// identify the image.
+func digFromPixels(tracker *progressTracker, imagePath string, pixels *pixelBuffer, info imgInfo, opts *Options, pHash int64, logger Logger) (*dugPart, error) {
+	bitsPerChannel := uint8(util.Min(int(opts.maxBitsPerChannel), int(info.Format.BitsPerChannel)))
+
+	logger.Log(OutputInfo,
 		fmt.Sprintf("Image info:\n\tDimensions: %dx%dpx\n\tColour model: %v\n\tChannels per pixel: %d\n\tBits per channel: %d",
 		info.W, info.H, colourModelToStr(info.Format.Model), info.Format.ChannelsPerPix, info.Format.BitsPerChannel))
 
-
-	printlnLvl(outputLevel, OutputSteps, "Loading up the pattern key...")
-	pHash, err := hashPatternFile(config.PatternPath)
+	mask, err := buildMask(opts.maskRects, opts.maskPath, opts.maskExclude, info, logger)
 	if err != nil {
-		printlnLvl(outputLevel, OutputSteps,
-			fmt.Sprintf("Something went wrong while attempting to hash the pattern file '%v'.", config.PatternPath))
-		return err
+		return nil, err
+	}
+	if mask != nil {
+		logger.Log(OutputInfo, fmt.Sprintf("The mask allows %d of %d pixels to be used.", mask.count(), len(mask)))
 	}
-	printlnLvl(outputLevel, OutputInfo, "Pattern hash:", pHash)
 
+	logger.Log(OutputSteps, "Reading the file from the image...")
 
-	printlnLvl(outputLevel, OutputSteps, "Reading the file from the image...")
+	if opts.algorithm == algos.AlgoRobust {
+		return digRobust(tracker, imagePath, opts, pixels, info, pHash, logger)
+	}
 
 	channelsPerPix := info.Format.ChannelsPerPix
-	if info.Format.supportsAlpha() && !config.DecodeAlpha {
+	if info.Format.supportsAlpha() && !opts.alpha {
 		channelsPerPix--
 	}
 	if channelsPerPix <= 0 { // In the case of Alpha & Alpha16 models
-		return &InsufficientHidingSpotsError{AdditionalInfo:fmt.Sprintf("The provided image is of the %v colour" +
+		return nil, &InsufficientHidingSpotsError{AdditionalInfo:fmt.Sprintf("The provided image is of the %v colour" +
 			"model, but since alpha-channel encoding was not specified, there are no channels to hide data within.",
 			colourModelToStr(info.Format.Model))}
 	}
 
-	channelCount := int64(len(*pixels)) * int64(channelsPerPix)
-	printlnLvl(outputLevel, OutputInfo, "Maximum readable bits:", channelCount * int64(config.MaxBitsPerChannel))
+	channelCount := pixels.count() * int64(channelsPerPix)
+	logger.Log(OutputInfo, "Counted the readable bits of the image.", "bits", channelCount * int64(bitsPerChannel))
 
-	f, err := algos.AlgoAddressor(config.Algorithm, pHash, channelCount, config.MaxBitsPerChannel)
+	f, reseed, err := algos.ReseedableAlgoAddressor(opts.algorithm, pHash, channelCount, bitsPerChannel)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-
-	var eccConfig *bch.EncodingConfig = nil
-	if config.MaxCorrectableErrors > 0 {
-		printlnLvl(outputLevel, OutputSteps, "Setting up data ECC...")
-		chunkBitSize := util.Max(int(encodeChunkSize), int(encodeHeaderSize)) * int(bitsPerByte)
-		codeLength, err := bch.TotalBitsForConfig(chunkBitSize, int(config.MaxCorrectableErrors))
-		if err != nil {
-			return err
+	stream := &bitStream{
+		pixels:         pixels,
+		info:           info,
+		mask:           mask,
+		pos:            f,
+		channels:       channelsPerPix,
+		bitsPerChannel: bitsPerChannel,
+		msb:            opts.msb,
+		// Sequential streams lay the header copies out across the pool, so anything before them is kept clear of them
+		claim:          opts.algorithm == algos.AlgoSequential,
+		logger:         logger,
+	}
+	if opts.algorithm == algos.AlgoPattern && !opts.publicKeyMode() {
+		if err = reserveKeyHint(stream); err != nil {
+			return nil, err
 		}
+	}
 
-		eccConfig, err = bch.CreateConfig(codeLength, int(config.MaxCorrectableErrors))
+
+	var key []byte
+	if opts.publicKeyMode() {
+		logger.Log(OutputSteps, "Reading the ephemeral public key...")
+		bits, err := stream.readBits(keyHintBits)
 		if err != nil {
-			return err
+			switch err.(type) {
+			case *algos.EmptyPoolError:
+				return nil, &InsufficientHidingSpotsError{InnerError:err}
+			default:
+				return nil, err
+			}
 		}
-		printlnLvl(outputLevel, OutputInfo, fmt.Sprintf("Using a %v. This has a ratio (errors : bits) of %2.2f%%.",
-			eccConfig, 100 * eccConfig.ECCRatio()))
+		var rep [KeySize]byte
+		copy(rep[:], *binmani.BitsToBytes(bits, false))
+		session := opts.session
+		if session == nil {
+			private, err := ReadKeyFile(opts.privateKeyPath)
+			if err != nil {
+				logger.Log(OutputSteps, "Something went wrong while attempting to load the private key.")
+				return nil, err
+			}
+			session = &keySession{rep: rep}
+			if session.seed, session.key, err = openEphemeralKey(rep, private); err != nil {
+				return nil, err
+			}
+		} else if rep != session.rep {
+			return nil, &InvalidFormatError{"The ephemeral public key in the image is damaged."}
+		}
+		reseed(session.seed)
+		key = session.key
 	}
 
+	logger.Log(OutputSteps, "Reading steg header...")
 
-	eccErrors := 0
-
-	printlnLvl(outputLevel, OutputSteps, "Reading steg header...")
-
-	b, header := make([]byte, encodeChunkSize), make([]byte, encodeHeaderSize)
-	if eccErrors, err = decodeChunk(config, eccConfig, info, &f, pixels, channelsPerPix, &header, int(encodeHeaderSize), outputLevel); err != nil {
-		switch err.(type) {
-		case *algos.EmptyPoolError:
-			return &InsufficientHidingSpotsError{InnerError:err}
-		default:
-			return err
+	headerECC, err := createECCConfig(int(encodeHeaderSize), encodeHeaderErrors)
+	if err != nil {
+		return nil, err
+	}
+	headerCopies := make([][]uint8, int(opts.HeaderCopies()))
+	for i := range headerCopies {
+		if opts.algorithm == algos.AlgoSequential {
+			stream.seekHeaderCopy(i, len(headerCopies))
+		}
+		if headerCopies[i], err = stream.readBits(chunkCodeLength(headerECC, int(encodeHeaderSize))); err != nil {
+			switch err.(type) {
+			case *algos.EmptyPoolError:
+				return nil, &InsufficientHidingSpotsError{InnerError:err}
+			default:
+				return nil, err
+			}
 		}
 	}
+	header, eccErrors, err := decodeHeader(headerECC, headerCopies, logger)
+	if err != nil {
+		return nil, err
+	}
 
-	headerStr := string(header[0:])
+	logger.Log(OutputDebug, "Decoded header.", "header", header)
 
-	if outputLevel == OutputDebug {
-		fmt.Println("Encoding header:", headerStr)
-		for _, v := range header {
-			fmt.Printf("%#08b\n", v)
+	// The first copy of the header is in the same place whatever the number of copies, but the rest of them (and so the
+	// file data after them) aren't, so the image is read again if the number doesn't match
+	if copies := uint8(util.Max(int(header[17]), 1)); copies != opts.HeaderCopies() {
+		if opts.headerCopiesRead {
+			logger.Log(OutputSteps, "The number of header copies in the header doesn't match the one it was read with.")
+			return nil, &BadHeaderError{}
 		}
+		logger.Log(OutputInfo, fmt.Sprintf("The image has %d copies of the steg header, not %d, so it's being read " +
+			"again.", copies, opts.HeaderCopies()))
+		return digFromPixels(tracker, imagePath, pixels, info, opts.With(withHeaderCopiesRead(copies)), pHash, logger)
 	}
 
 	encodeVersionMax := header[0]
@@ -162,132 +279,214 @@ func Dig(config *DigConfig, outputLevel OutputLevel) error {
 	fileSize += int64(header[5])
 	fileSize <<= 8
 	fileSize += int64(header[6])
+	if opts, err = opts.withLayout(header[7:7 + layoutSize]); err != nil {
+		logger.Log(OutputSteps, "The data layout settings in the header are not valid.")
+		return nil, err
+	}
+	part, err := parsePartInfo(header[10:10 + partInfoSize])
+	if err != nil {
+		return nil, err
+	}
+	if part.count > 1 {
+		logger.Log(OutputInfo, fmt.Sprintf("This image holds part %d of %d of the file.", part.index + 1, part.count),
+			"set", part.setID)
+	}
 
-	/*if err != nil {
-		fmt.Println("The read file size is not valid!")
-		return err
-	}*/
-
-	printlnLvl(outputLevel, OutputInfo, fmt.Sprintf("This image was encoded with steg v%d.%d.%d.",
+	logger.Log(OutputInfo, fmt.Sprintf("This image was encoded with steg v%d.%d.%d.",
 		encodeVersionMax, encodeVersionMid, encodeVersionMin))
 
 	if encodeVersionMax != VersionMax || encodeVersionMid != VersionMid || encodeVersionMin != VersionMin {
-		printlnLvl(outputLevel, OutputSteps,
-			"This image was encoded with a different version of Steg. The program will continue, but in the case",
+		logger.Log(OutputSteps,
+			"This image was encoded with a different version of Steg. The program will continue, but in the case " +
 			"of strange errors or issues, try using the same version as the image was originally encoded with.")
 	}
 
-	printlnLvl(outputLevel, OutputInfo, fmt.Sprintf("Output file size: %d B", fileSize))
+	logger.Log(OutputInfo, fmt.Sprintf("Output file size: %d B", fileSize))
+	tracker.start(fileSize, eccErrors)
 
+	var eccConfig *bch.EncodingConfig = nil
+	if opts.maxCorrectableErrors > 0 {
+		logger.Log(OutputSteps, "Setting up data ECC...")
+		eccConfig, err = createECCConfig(int(encodeChunkSize), opts.maxCorrectableErrors)
+		if err != nil {
+			return nil, err
+		}
+		logger.Log(OutputInfo, fmt.Sprintf("Using a %v. This has a ratio (errors : bits) of %2.2f%%.",
+			eccConfig, 100 * eccConfig.ECCRatio()))
+	}
 
-	printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Creating the output file at '%v'...", config.OutPath))
-	outFile, err := os.Create(config.OutPath)
-	if err != nil {
-		printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("There was an error creating the file '%v'.", config.OutPath))
-		return err
+	sizes := chunkSizes(fileSize, opts.recoveryChunks)
+	lengths := make([]int, len(sizes))
+	totalLength := int64(0)
+	for i, n := range sizes {
+		lengths[i] = chunkCodeLength(eccConfig, n)
+		totalLength += int64(lengths[i])
+	}
+	if totalLength > channelCount * int64(bitsPerChannel) {
+		logger.Log(OutputSteps, "The file size in the header is larger than the image could possibly hold.")
+		return nil, &BadHeaderError{}
 	}
 
-	defer func() {
-		if err = outFile.Close(); err != nil {
-			printlnLvl(outputLevel, OutputSteps, "Error closing the file:", err.Error())
-		}
-	}()
 
+	logger.Log(OutputSteps, "Reading file data...")
+	if opts.algorithm == algos.AlgoSequential {
+		stream.seekData(opts.interleave, totalLength)
+	}
 
-	printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Writing to the output file at '%v'...", config.OutPath))
-	readBytes := int64(0)
-	for readBytes < fileSize {
-		n := util.Min(int(encodeChunkSize), int(fileSize - readBytes))
-		if errors, err := decodeChunk(config, eccConfig, info, &f, pixels, channelsPerPix, &b, n, outputLevel); err != nil {
+	var codewords [][]uint8
+	if opts.interleave {
+		bits, err := stream.readBits(int(totalLength))
+		if err != nil {
 			switch err.(type) {
 			case *algos.EmptyPoolError:
-				return &InsufficientHidingSpotsError{InnerError:err}
+				return nil, &InsufficientHidingSpotsError{InnerError:err}
 			default:
-				return err
+				return nil, err
+			}
+		}
+		codewords = deinterleave(bits, lengths)
+	} else {
+		for _, length := range lengths {
+			if err = tracker.err(); err != nil {
+				return nil, err
 			}
-		} else {
-			eccErrors += errors
+			codeword, err := stream.readBits(length)
+			if err != nil {
+				switch err.(type) {
+				case *algos.EmptyPoolError:
+					return nil, &InsufficientHidingSpotsError{InnerError:err}
+				default:
+					return nil, err
+				}
+			}
+			codewords = append(codewords, codeword)
 		}
-		r, err := outFile.Write(b[:n])
+	}
+
+	chunks, chunkErrors, errs, err := decodeChunks(tracker, eccConfig, codewords, sizes, chunkDataBytes(fileSize, opts.recoveryChunks), logger)
+	if err != nil {
+		return nil, err
+	}
+	lostChunks := 0
+	for i, err := range errs {
 		if err != nil {
-			return err
+			switch err.(type) {
+			case bch.DataTooCorruptError:
+				if opts.recoveryChunks > 0 {
+					logger.Log(OutputDebug, "Chunk is too corrupted to decode, so it will be recovered.", "chunk", i)
+					lostChunks++
+					continue
+				}
+				return nil, err
+			default:
+				return nil, err
+			}
 		}
-		readBytes += int64(r)
+		eccErrors += chunkErrors[i]
 	}
 
-	if config.MaxCorrectableErrors > 0 {
-		printlnLvl(outputLevel, OutputInfo, fmt.Sprintf("There were %d error(s) in the image.", eccErrors))
+	if opts.recoveryChunks > 0 {
+		logger.Log(OutputInfo, fmt.Sprintf("%d chunk(s) were lost and need to be recovered.", lostChunks))
+		if chunks, err = recoverChunks(chunks, fileSize, opts.recoveryChunks); err != nil {
+			return nil, err
+		}
 	}
 
+	if opts.maxCorrectableErrors > 0 {
+		logger.Log(OutputInfo, fmt.Sprintf("There were %d error(s) in the image.", eccErrors))
+	}
 
-	printlnLvl(outputLevel, OutputSteps, "All done! c:")
+	data := bytes.Join(chunks, nil)
+	if key != nil {
+		logger.Log(OutputSteps, "Decrypting the file data...")
+		if data, err = openData(key, data); err != nil {
+			return nil, err
+		}
+	}
 
-	return nil
+	return &dugPart{imagePath, data, part, opts, eccErrors}, nil
 }
 
-// Helper functions
-
-func decodeChunk(config *DigConfig, eccConfig *bch.EncodingConfig, info imgInfo, pos *func() (int64, error), pixels *[]pixel, channelCount uint8, buf *[]byte, n int, outputLevel OutputLevel) (int, error) {
-	supportsAlpha := info.Format.supportsAlpha()
-	alphaChannel := info.Format.alphaChannel()
+// decodeChunks decodes each of codewords into a chunk of the corresponding size with decodeChunk, in parallel.
+// The results are returned for every chunk, so that the caller can decide what to do about the ones that failed.
+// dataBytes holds the number of bytes of the file in each chunk, for progress reporting. The returned error is only set
+// if the operation was cancelled.
+func decodeChunks(tracker *progressTracker, eccConfig *bch.EncodingConfig, codewords [][]uint8, sizes, dataBytes []int, logger Logger) (chunks [][]byte, errors []int, errs []error, err error) {
+	chunks = make([][]byte, len(codewords))
+	errors = make([]int, len(codewords))
+	errs = make([]error, len(codewords))
+	err = forEachChunk(tracker, len(codewords), logger, func(i int) {
+		chunks[i], errors[i], errs[i] = decodeChunk(eccConfig, codewords[i], sizes[i], logger)
+		tracker.add(int64(dataBytes[i]), util.Max(errors[i], 0))
+	})
+	return
+}
 
-	readLength := n * int(bitsPerByte)
-	if eccConfig != nil {
-		readLength += eccConfig.ChecksumBits()
+// decodeHeader decodes the steg header from all of its copies. The copies are combined with a majority vote on each
+// bit before decoding, and if that fails (which is possible with an even number of copies), each copy is tried alone.
+// It returns the header and the number of errors that were corrected.
+func decodeHeader(headerECC *bch.EncodingConfig, copies [][]uint8, logger Logger) ([]byte, int, error) {
+	voted := make([]uint8, len(copies[0]))
+	for i := range voted {
+		votes := 0
+		for _, c := range copies {
+			votes += int(c[i])
+		}
+		if votes * 2 > len(copies) {
+			voted[i] = 1
+		}
 	}
-	codeBits := make([]uint8, readLength)
-	for i := 0; i < readLength; i++ {
-		for {
-			addr, err := (*pos)()
-			if err != nil {
-				return -1, err
-			}
-			p, c, b := bitAddrToPCB(addr, channelCount, config.MaxBitsPerChannel)
-
-			if outputLevel == OutputDebug {
-				fmt.Printf("addr: %d, pixel: %d, channel: %d, bit: %d, RGBA: %v\n", addr, p, c, b, (*pixels)[p])
-			}
-
-			// TODO: Note that this has the potential to introduce nasty bugs if a (0,0,0,1) is turned into a (0,0,0,0)
-			if supportsAlpha && (*pixels)[p][alphaChannel] <= 0 {
-				continue
-			}
-
-			bitPos := b
-			if config.DecodeMsb {
-				bitPos = bitsPerByte - b - 1
-			}
-
-			readBit := binmani.ReadFrom((*pixels)[p][c], bitPos, 1)
-			codeBits[i] = uint8(readBit)
-
-			if outputLevel == OutputDebug {
-				fmt.Printf("	Read %d\n", readBit)
-			}
 
-			break
+	header, errors, err := decodeChunk(headerECC, voted, int(encodeHeaderSize), logger)
+	if err == nil || len(copies) <= 1 {
+		return header, errors, err
+	}
+	for i, c := range copies {
+		if header, errors, err = decodeChunk(headerECC, c, int(encodeHeaderSize), logger); err == nil {
+			logger.Log(OutputInfo, fmt.Sprintf("The combined header was unreadable, so copy %d was used.", i))
+			return header, errors, nil
 		}
 	}
+	return nil, -1, err
+}
+
+// fullCodeLength returns the length of the full (unshortened) BCH code that eccConfig is based on.
+func fullCodeLength(eccConfig *bch.EncodingConfig) int {
+	m := int(math.Log2(float64(eccConfig.CodeLength))) + 1
+	return 1 << uint(m) - 1
+}
 
+// decodeChunk converts the bits read for a chunk of n bytes back into data, correcting errors if eccConfig is set.
+// It returns the data and the number of errors that were corrected.
+func decodeChunk(eccConfig *bch.EncodingConfig, codeBits []uint8, n int, logger Logger) ([]byte, int, error) {
+	var buf []byte
 	var eccErrors int
 	if eccConfig != nil {
-		printlnLvl(outputLevel, OutputDebug, codeBits)
-		padBits := make([]uint8, eccConfig.CodeLength - readLength)
+		logger.Log(OutputDebug, "Decoding chunk.", "bits", codeBits)
+		// The codeword is shortened, so it is padded back out with zeroes. It is padded beyond the code length to the
+		// full length of the underlying code as well, since a badly corrupted codeword can be "corrected" at any
+		// position of it. Any correction outside of the read bits means the codeword was miscorrected.
+		readLength := len(codeBits)
+		padBits := make([]uint8, fullCodeLength(eccConfig) - readLength)
 		codeBits = append(codeBits, padBits...)
 		decodedBits, errors, err := bch.Decode(eccConfig, &codeBits)
 		if err != nil {
-			return -1, err
+			return nil, -1, err
 		}
-		printlnLvl(outputLevel, OutputDebug, "Errors in this chunk:", errors)
-		*buf = *binmani.BitsToBytes(decodedBits, false)
+		for _, bit := range codeBits[readLength:] {
+			if bit != 0 {
+				return nil, -1, bch.DataTooCorruptError{}
+			}
+		}
+		decodedBits = decodedBits[:eccConfig.StorageBits]
+		logger.Log(OutputDebug, "Decoded chunk.", "errors", errors)
+		buf = *binmani.BitsToBytes(decodedBits, false)
 		eccErrors = errors
 	} else {
-		*buf = *binmani.BitsToBytes(codeBits, false)
+		buf = *binmani.BitsToBytes(codeBits, false)
 	}
 
-	if outputLevel == OutputDebug {
-		fmt.Println("Read chunk:", string(*buf))
-	}
+	logger.Log(OutputDebug, "Read chunk.", "data", buf[:n])
 
-	return eccErrors, nil
-}
\ No newline at end of file
+	return buf[:n], eccErrors, nil
+}
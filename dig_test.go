@@ -0,0 +1,176 @@
+package steg
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zedseven/steg/internal/algos"
+)
+
+// testImageSize is the width and height of the images that the tests hide files in.
+const testImageSize = 256
+
+// Tests
+
+func TestDecodeHeader(t *testing.T) {
+	headerECC, err := createECCConfig(int(encodeHeaderSize), encodeHeaderErrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	header := make([]byte, encodeHeaderSize)
+	r.Read(header)
+	bits, err := encodeChunk(headerECC, header, loggerOrNop(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		damage func(copies [][]uint8)
+		ok     bool
+	}{
+		{"one copy garbage", func(copies [][]uint8) {
+			r.Read(copies[1])
+			for i := range copies[1] {
+				copies[1][i] &= 1
+			}
+		}, true},
+		{"one copy inverted", func(copies [][]uint8) {
+			for i := range copies[2] {
+				copies[2][i] ^= 1
+			}
+		}, true},
+		// Every copy is too damaged to decode alone, but never in the same place as another one
+		{"every copy damaged", func(copies [][]uint8) {
+			for i := range copies {
+				for j := i; j < len(bits); j += len(copies) {
+					copies[i][j] ^= 1
+				}
+			}
+		}, true},
+		// Two copies agree on the same damage, and outvote the third
+		{"two copies damaged alike", func(copies [][]uint8) {
+			for j := 0; j < 2 * int(encodeHeaderErrors); j++ {
+				copies[0][j] ^= 1
+				copies[1][j] ^= 1
+				copies[2][len(bits) - 1 - j] ^= 1
+			}
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			copies := make([][]uint8, 3)
+			for i := range copies {
+				copies[i] = append([]uint8(nil), bits...)
+			}
+			test.damage(copies)
+
+			got, _, err := decodeHeader(headerECC, copies, loggerOrNop(nil))
+			if !test.ok {
+				if err == nil && bytes.Equal(got, header) {
+					t.Fatal("The header was decoded from copies that are mostly wrong.")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, header) {
+				t.Fatalf("Decoded %v, want %v", got, header)
+			}
+		})
+	}
+}
+
+// TestHeaderCopiesMismatch checks that an image can be dug up with any number of header copies, whichever number it
+// was hidden with.
+func TestHeaderCopiesMismatch(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	imagePath := writeTestImage(t, dir)
+	filePath, data := writeTestFile(t, dir, 600)
+
+	for _, algo := range []algos.Algo{algos.AlgoSequential, algos.AlgoPattern} {
+		for _, hidden := range []uint8{1, 3} {
+			outPath := filepath.Join(dir, "out.png")
+			opts := NewOptions(WithAlgorithm(algo), WithPassphrase("hunter2"), WithHeaderCopies(hidden))
+			if _, err := Hide(&HideConfig{ImagePath: imagePath, FilePath: filePath, OutPath: outPath, Options: opts},
+				nil); err != nil {
+				t.Fatal(err)
+			}
+			for _, dug := range []uint8{1, 2, 3, 5} {
+				got := digTestFile(t, dir, outPath, opts.With(WithHeaderCopies(dug)))
+				if !bytes.Equal(got, data) {
+					t.Errorf("%v, hidden with %d header copies and dug with %d: the file is wrong.", algo, hidden, dug)
+				}
+			}
+		}
+	}
+}
+
+// Helper functions
+
+// tempDir makes a temporary directory for a test.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "steg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writeTestImage writes an opaque image of random noise to dir, and returns its path.
+func writeTestImage(t *testing.T, dir string) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, testImageSize, testImageSize))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	p := filepath.Join(dir, "carrier.png")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// writeTestFile writes n random bytes to a file in dir, and returns its path and contents.
+func writeTestFile(t *testing.T, dir string, n int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	p := filepath.Join(dir, "file.bin")
+	if err := ioutil.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p, data
+}
+
+// digTestFile digs up the file hidden in the image at imagePath with opts, and returns its contents, or nil if it
+// couldn't be dug up.
+func digTestFile(t *testing.T, dir, imagePath string, opts *Options) []byte {
+	t.Helper()
+	outPath := filepath.Join(dir, "dug.bin")
+	_ = os.Remove(outPath)
+	if err := Dig(&DigConfig{ImagePath: imagePath, OutPath: outPath, Options: opts}, nil); err != nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,278 @@
+package steg
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// RS analysis and Sample Pair Analysis both estimate how much of a colour channel has data embedded in its
+// least-significant bits, from how the embedding disturbs the relationships between neighbouring pixels. They work on
+// each colour channel of the image on its own, as a plane of W x H values, and they work best on images that were
+// never lossy-compressed.
+//
+// Both give the embedding rate: the fraction of the channel values that carry a bit of data. Since about half of those
+// bits already had the right value, the fraction of LSBs that were actually changed is about half of it.
+
+const (
+	// rsGroupSize is the number of horizontally adjacent values in each group of RS analysis.
+	rsGroupSize int = 4
+)
+
+// rsMask is the flipping mask applied to each group of RS analysis.
+var rsMask = [rsGroupSize]int{0, 1, 1, 0}
+
+// Types
+
+// ChannelEstimate is an estimate of how much data is embedded in one colour channel of an image.
+type ChannelEstimate struct {
+	// Channel is the name of the colour channel.
+	Channel  string
+	// Rate is the estimated fraction of the channel values that carry embedded data, between 0 and 1.
+	Rate     float64
+	// Modified is the estimated fraction of the LSBs of the channel that were changed, which is half of Rate.
+	Modified float64
+}
+
+// channelPlane is the values of one colour channel of an image, row by row.
+type channelPlane struct {
+	name   string
+	values []int
+	w, h   int
+}
+
+// Library methods
+
+// RSAnalysis estimates the embedding rate of each colour channel of img with the RS (Regular/Singular groups)
+// steganalysis of Fridrich, Goljan and Du. Each row is split into groups of 4 values, which are classified by whether
+// flipping their LSBs makes them more or less noisy. Embedding pushes the counts of the regular and singular groups
+// together, in a way that can be solved for the embedding rate.
+func RSAnalysis(img image.Image) ([]ChannelEstimate, error) {
+	planes, err := channelPlanes(img)
+	if err != nil {
+		return nil, err
+	}
+
+	estimates := make([]ChannelEstimate, len(planes))
+	for i, plane := range planes {
+		estimates[i] = newChannelEstimate(plane.name, rsRate(plane))
+	}
+	return estimates, nil
+}
+
+// SamplePairAnalysis estimates the embedding rate of each colour channel of img with the Sample Pair Analysis of
+// Dumitrescu, Wu and Wang. It looks at every pair of horizontally adjacent values, and at how embedding moves pairs
+// between the sets of pairs whose order depends on their LSBs.
+func SamplePairAnalysis(img image.Image) ([]ChannelEstimate, error) {
+	planes, err := channelPlanes(img)
+	if err != nil {
+		return nil, err
+	}
+
+	estimates := make([]ChannelEstimate, len(planes))
+	for i, plane := range planes {
+		estimates[i] = newChannelEstimate(plane.name, spaRate(plane))
+	}
+	return estimates, nil
+}
+
+// Helper functions
+
+// newChannelEstimate creates a ChannelEstimate from an embedding rate, clamping it to between 0 and 1.
+func newChannelEstimate(name string, rate float64) ChannelEstimate {
+	if math.IsNaN(rate) || rate <= 0 {
+		rate = 0
+	} else if rate > 1 {
+		rate = 1
+	}
+	return ChannelEstimate{Channel: name, Rate: rate, Modified: rate / 2}
+}
+
+// channelPlanes splits img into the planes of its colour channels. The alpha channel is left out.
+func channelPlanes(img image.Image) ([]channelPlane, error) {
+	pixels, info, err := pixelsFromImage(img)
+	if err != nil {
+		return nil, err
+	}
+	// Sub-images don't have contiguous pixels, so they are copied first
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if img.Bounds().Min != (image.Point{}) || pixels.count() != int64(w) * int64(h) {
+		var nimg draw.Image
+		if info.Format.BitsPerChannel > 8 {
+			nimg = image.NewNRGBA64(image.Rect(0, 0, w, h))
+		} else {
+			nimg = image.NewNRGBA(image.Rect(0, 0, w, h))
+		}
+		draw.Draw(nimg, nimg.Bounds(), img, img.Bounds().Min, draw.Src)
+		if pixels, info, err = pixelsFromImage(nimg); err != nil {
+			return nil, err
+		}
+	}
+
+	names := channelNames(info.Format.Model)
+	var planes []channelPlane
+	for c := uint8(0); c < info.Format.ChannelsPerPix; c++ {
+		if info.Format.supportsAlpha() && int8(c) == info.Format.alphaChannel() {
+			continue
+		}
+		plane := channelPlane{name: names[c], values: make([]int, pixels.count()), w: w, h: h}
+		for p := range plane.values {
+			plane.values[p] = int(pixels.channel(int64(p), c))
+		}
+		planes = append(planes, plane)
+	}
+	if len(planes) <= 0 {
+		return nil, &InvalidFormatError{"The image has no colour channels to analyze."}
+	}
+	return planes, nil
+}
+
+// channelNames returns the names of the channels of a colour model, in order.
+func channelNames(model color.Model) []string {
+	switch model {
+	case color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model:
+		return []string{"red", "green", "blue", "alpha"}
+	case color.CMYKModel:
+		return []string{"cyan", "magenta", "yellow", "black"}
+	case color.GrayModel, color.Gray16Model:
+		return []string{"grey"}
+	default:
+		return []string{"alpha"}
+	}
+}
+
+// channelLetters returns the letter of each channel of a colour model, in the same order as channelNames. They're
+// given explicitly, since the first letters of the names clash (black and blue, grey and green).
+func channelLetters(model color.Model) string {
+	switch model {
+	case color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model:
+		return "rgba"
+	case color.CMYKModel:
+		return "cmyk"
+	case color.GrayModel, color.Gray16Model:
+		return "g"
+	default:
+		return "a"
+	}
+}
+
+// rsRate estimates the embedding rate of a plane with RS analysis.
+func rsRate(plane channelPlane) float64 {
+	// The counts are taken for the plane as it is, and with all of its LSBs flipped
+	rm0, sm0, rn0, sn0 := rsCounts(plane, false)
+	rm1, sm1, rn1, sn1 := rsCounts(plane, true)
+	d0, d1 := rm0 - sm0, rm1 - sm1
+	dn0, dn1 := rn0 - sn0, rn1 - sn1
+
+	// The smaller root of 2(d1 + d0)z^2 + (d-0 - d-1 - d1 - 3d0)z + d0 - d-0 = 0 gives the rate
+	z, ok := smallerRoot(2 * (d1 + d0), dn0 - dn1 - d1 - 3 * d0, d0 - dn0)
+	if !ok || z == 0.5 {
+		return 0
+	}
+	return z / (z - 0.5)
+}
+
+// rsCounts returns the fractions of the groups of a plane that are regular and singular under the mask M, and under
+// the negative mask -M. If flipped is set, all of the LSBs of the plane are flipped first.
+func rsCounts(plane channelPlane, flipped bool) (rm, sm, rn, sn float64) {
+	groups := 0
+	var group, shifted, negative [rsGroupSize]int
+	for y := 0; y < plane.h; y++ {
+		row := plane.values[y * plane.w:(y + 1) * plane.w]
+		for x := 0; x + rsGroupSize <= len(row); x += rsGroupSize {
+			for i := range group {
+				group[i] = row[x + i]
+				if flipped {
+					group[i] ^= 1
+				}
+				shifted[i], negative[i] = group[i], group[i]
+				if rsMask[i] != 0 {
+					shifted[i] = group[i] ^ 1
+					negative[i] = (group[i] + 1) ^ 1 - 1
+				}
+			}
+
+			f := rsSmoothness(group[:])
+			switch fm := rsSmoothness(shifted[:]); {
+			case fm > f:
+				rm++
+			case fm < f:
+				sm++
+			}
+			switch fn := rsSmoothness(negative[:]); {
+			case fn > f:
+				rn++
+			case fn < f:
+				sn++
+			}
+			groups++
+		}
+	}
+	if groups <= 0 {
+		return 0, 0, 0, 0
+	}
+	n := float64(groups)
+	return rm / n, sm / n, rn / n, sn / n
+}
+
+// rsSmoothness is the discrimination function of RS analysis, which measures how noisy a group is.
+func rsSmoothness(group []int) int {
+	sum := 0
+	for i := 0; i + 1 < len(group); i++ {
+		d := group[i + 1] - group[i]
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return sum
+}
+
+// spaRate estimates the embedding rate of a plane with Sample Pair Analysis.
+func spaRate(plane channelPlane) float64 {
+	var x, y, gamma, pairs float64
+	for row := 0; row < plane.h; row++ {
+		values := plane.values[row * plane.w:(row + 1) * plane.w]
+		for i := 0; i + 1 < len(values); i++ {
+			u, v := values[i], values[i + 1]
+			if v & 1 == 0 && u < v || v & 1 == 1 && u > v {
+				x++
+			}
+			if v & 1 == 0 && u > v || v & 1 == 1 && u < v {
+				y++
+			}
+			if u >> 1 == v >> 1 {
+				gamma++
+			}
+			pairs++
+		}
+	}
+
+	// The smaller root of 2γβ^2 + 2(2|X| - |P|)β + |Y| - |X| = 0 is the fraction of LSBs that were changed
+	beta, ok := smallerRoot(2 * gamma, 2 * (2 * x - pairs), y - x)
+	if !ok {
+		return 0
+	}
+	return 2 * beta
+}
+
+// smallerRoot returns the root of ax^2 + bx + c = 0 that is closest to 0. ok is false if there are no real roots.
+func smallerRoot(a, b, c float64) (float64, bool) {
+	if a == 0 {
+		if b == 0 {
+			return 0, false
+		}
+		return -c / b, true
+	}
+	disc := b * b - 4 * a * c
+	if disc < 0 {
+		return 0, false
+	}
+	r1 := (-b + math.Sqrt(disc)) / (2 * a)
+	r2 := (-b - math.Sqrt(disc)) / (2 * a)
+	if math.Abs(r1) < math.Abs(r2) {
+		return r1, true
+	}
+	return r2, true
+}
@@ -5,4 +5,5 @@ go 1.13
 require (
 	github.com/zedseven/bch v0.0.0-20200206041947-98defa56dee2
 	github.com/zedseven/binmani v0.0.0-20200205224959-04362b2575eb
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 )
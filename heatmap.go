@@ -0,0 +1,115 @@
+package steg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// A heatmap shows where an image was modified. The difference of each pixel is the sum of the absolute differences of
+// its channels, which is scaled so that the most-changed pixel of the image is at the top of the colour ramp - so even
+// changes of a single least-significant bit show up. Unchanged pixels are black, or a dimmed greyscale copy of the
+// original image if it's overlaid.
+
+const (
+	// heatmapDim is the fraction of its brightness that the original image keeps when it's overlaid.
+	heatmapDim float64 = 0.3
+)
+
+// heatmapRamp is the colour ramp of a heatmap, from the smallest differences to the largest.
+var heatmapRamp = []color.RGBA{
+	{0x00, 0x00, 0xff, 0xff},
+	{0x00, 0xff, 0xff, 0xff},
+	{0x00, 0xff, 0x00, 0xff},
+	{0xff, 0xff, 0x00, 0xff},
+	{0xff, 0x00, 0x00, 0xff},
+}
+
+// Types
+
+// HeatmapConfig stores the configuration options for RenderHeatmap.
+type HeatmapConfig struct {
+	// OriginalPath is the path on disk to the original image.
+	OriginalPath string
+	// ModifiedPath is the path on disk to the modified image, which must have the same dimensions.
+	ModifiedPath string
+	// OutPath is the path on disk to write the heatmap to, as a PNG.
+	OutPath      string
+	// Overlay is whether to draw the heatmap over a dimmed copy of the original image, instead of over black.
+	Overlay      bool
+}
+
+// Library methods
+
+// RenderHeatmap renders where the image at config.ModifiedPath differs from the one at config.OriginalPath.
+func RenderHeatmap(config *HeatmapConfig, logger Logger) error {
+	logger = loggerOrNop(logger)
+
+	if len(config.OutPath) <= 0 {
+		return &InvalidFormatError{"OutPath is empty."}
+	}
+	a, b, info, err := loadComparison(config.OriginalPath, config.ModifiedPath, logger)
+	if err != nil {
+		return err
+	}
+
+	logger.Log(OutputSteps, "Rendering the heatmap...")
+	diffs := make([]uint32, a.count())
+	largest := uint32(0)
+	for p := range diffs {
+		for c := uint8(0); c < info.Format.ChannelsPerPix; c++ {
+			av, bv := a.channel(int64(p), c), b.channel(int64(p), c)
+			if av > bv {
+				diffs[p] += uint32(av - bv)
+			} else {
+				diffs[p] += uint32(bv - av)
+			}
+		}
+		if diffs[p] > largest {
+			largest = diffs[p]
+		}
+	}
+
+	w := int(info.W)
+	out := image.NewRGBA(image.Rect(0, 0, w, int(info.H)))
+	changed := 0
+	for p, d := range diffs {
+		x, y := p % w, p / w
+		switch {
+		case d > 0:
+			out.SetRGBA(x, y, heatmapColour(float64(d) / float64(largest)))
+			changed++
+		case config.Overlay:
+			g := uint8(float64(color.GrayModel.Convert(a.img.At(x, y)).(color.Gray).Y) * heatmapDim)
+			out.SetRGBA(x, y, color.RGBA{g, g, g, 0xff})
+		default:
+			out.SetRGBA(x, y, color.RGBA{0x00, 0x00, 0x00, 0xff})
+		}
+	}
+	logger.Log(OutputInfo, fmt.Sprintf("%d of %d pixels were changed.", changed, len(diffs)))
+
+	logger.Log(OutputSteps, fmt.Sprintf("Writing the heatmap to '%v' now...", config.OutPath))
+	if err = writePNG(out, config.OutPath); err != nil {
+		logger.Log(OutputSteps, "An error occurred while writing the heatmap.")
+		return err
+	}
+
+	return nil
+}
+
+// Helper functions
+
+// heatmapColour returns the colour of the ramp at t, which is between 0 and 1.
+func heatmapColour(t float64) color.RGBA {
+	pos := t * float64(len(heatmapRamp) - 1)
+	i := int(pos)
+	if i >= len(heatmapRamp) - 1 {
+		return heatmapRamp[len(heatmapRamp) - 1]
+	}
+	f := pos - float64(i)
+	lerp := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b) - float64(a)) * f + 0.5)
+	}
+	c0, c1 := heatmapRamp[i], heatmapRamp[i + 1]
+	return color.RGBA{lerp(c0.R, c1.R), lerp(c0.G, c1.G), lerp(c0.B, c1.B), 0xff}
+}
@@ -1,11 +1,8 @@
 package steg
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"io"
-	"math"
-	"os"
 
 	"github.com/zedseven/bch"
 	"github.com/zedseven/binmani"
@@ -16,99 +13,271 @@ import (
 // HideConfig stores the configuration options for the Hide operation.
 type HideConfig struct {
 	// ImagePath is the path on disk to a supported image.
-	ImagePath            string
-	// FilePath is the path on disk to the file to hide.
-	FilePath             string
+	ImagePath      string
+	// FilePath is the path on disk to the file to hide. If it's a directory, everything in it is hidden as an archive.
+	FilePath       string
+	// FilePaths is a list of paths on disk to files and directories to hide together as an archive, which Dig extracts
+	// into a directory. If it's set, FilePath is ignored.
+	FilePaths      []string
 	// OutPath is the path on disk to write the output image.
-	OutPath              string
-	// PatternPath is the path on disk to the pattern file used in encoding.
-	PatternPath          string
-	// Algorithm is the algorithm to use in the operation.
-	Algorithm            algos.Algo
-	// MaxCorrectableErrors is the number of bit errors to be able to correct for per file chunk. Setting it to 0 disables bit ECC.
-	MaxCorrectableErrors uint8
-	// MaxBitsPerChannel is the maximum number of bits to write per pixel channel.
-	// The minimum of this and the supported max of the image format is used.
-	MaxBitsPerChannel    uint8
-	// DecodeAlpha is whether or not to encode the alpha channel.
-	EncodeAlpha          bool
-	// EncodeMsb is whether to encode the most-significant bits instead - mostly for debugging.
-	EncodeMsb            bool
+	OutPath        string
+	// ImagePaths is a list of paths on disk to images to split the file across, for files that are too large for a
+	// single image. If it's set, ImagePath and OutPath are ignored.
+	ImagePaths     []string
+	// OutPaths is the list of paths on disk to write the output images to, one for each of ImagePaths.
+	OutPaths       []string
+	// Layers are additional files to hide in the same image, each under its own key, so that digging with one key
+	// never reveals the others. They're ordered from the outside in, after the main file, which is the outermost.
+	// Layers can't be used with ImagePaths.
+	Layers         []HideLayer
+	// Options are the settings to hide the file with. If nil, the defaults from NewOptions are used.
+	Options        *Options
+	// MeasureQuality is whether to compare every image that was written with the original, to report how much it was
+	// distorted (see HideReport).
+	MeasureQuality bool
 }
 
 // Hide hides the binary data of a file in a provided image on disk, and saves the result to a new image.
 // It has the option of using one of several different encoding algorithms, depending on user needs.
-func Hide(config *HideConfig, outputLevel OutputLevel) error {
+func Hide(config *HideConfig, logger Logger) (*HideReport, error) {
+	return HideContext(context.Background(), config, logger, nil)
+}
+
+// HideContext is Hide, but it stops between file chunks once ctx is cancelled, returning the error of ctx. If progress
+// is not nil, it is called as the file chunks are processed.
+func HideContext(ctx context.Context, config *HideConfig, logger Logger, progress ProgressFunc) (*HideReport, error) {
+	logger = loggerOrNop(logger)
+
 	// Input validation
-	if len(config.ImagePath) <= 0 {
-		return &InvalidFormatError{"ImagePath is empty."}
+	if len(config.ImagePaths) > 0 {
+		if len(config.OutPaths) != len(config.ImagePaths) {
+			return nil, &InvalidFormatError{fmt.Sprintf("OutPaths has %d path(s), but ImagePaths has %d.",
+				len(config.OutPaths), len(config.ImagePaths))}
+		}
+		if len(config.ImagePaths) > maxParts {
+			return nil, &InvalidFormatError{fmt.Sprintf("A file can only be split across up to %d images.", maxParts)}
+		}
+	} else {
+		if len(config.ImagePath) <= 0 {
+			return nil, &InvalidFormatError{"ImagePath is empty."}
+		}
+		if len(config.OutPath) <= 0 {
+			return nil, &InvalidFormatError{"OutPath is empty."}
+		}
 	}
-	if len(config.FilePath) <= 0 {
-		return &InvalidFormatError{"FilePath is empty."}
+	if len(config.FilePath) <= 0 && len(config.FilePaths) <= 0 {
+		return nil, &InvalidFormatError{"FilePath and FilePaths are both empty."}
 	}
-	if len(config.OutPath) <= 0 {
-		return &InvalidFormatError{"OutPath is empty."}
+	opts := config.Options
+	if opts == nil {
+		opts = NewOptions()
 	}
-	if len(config.PatternPath) <= 0 {
-		return &InvalidFormatError{"PatternPath is empty."}
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
-	if !config.Algorithm.IsValid() {
-		return &InvalidFormatError{"Algorithm is invalid."}
+	if len(opts.privateKeyPath) > 0 && len(opts.recipientPath) <= 0 {
+		return nil, &InvalidFormatError{"Hiding in public-key mode requires the recipient's public key, not a private key."}
 	}
-	if config.MaxCorrectableErrors < 0 {
-		return &InvalidFormatError{"MaxCorrectableErrors must be non-negative."}
+	if len(config.Layers) > 0 && len(config.ImagePaths) > 0 {
+		return nil, &InvalidFormatError{"Layers can't be hidden across several images."}
 	}
-	if config.MaxBitsPerChannel < 0 || config.MaxBitsPerChannel > 16 {
-		return &InvalidFormatError{fmt.Sprintf("MaxBitsPerChannel is outside the allowed range of 0-16: Provided %d.", config.MaxBitsPerChannel)}
+	if int(opts.threshold) > util.Max(len(config.ImagePaths), 1) {
+		return nil, &InvalidFormatError{fmt.Sprintf("Threshold (%d) is more than the number of images (%d).",
+			opts.threshold, util.Max(len(config.ImagePaths), 1))}
 	}
 
-	printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Steg v%d.%d.%d by Zacchary Dempsey-Plante.", VersionMax, VersionMid, VersionMin))
-	printlnLvl(outputLevel, OutputDebug, "This tool has been set to display debug output.")
+	logger.Log(OutputSteps, fmt.Sprintf("Steg v%d.%d.%d by Zacchary Dempsey-Plante.", VersionMax, VersionMid, VersionMin))
+	logger.Log(OutputDebug, "This tool has been set to display debug output.")
+
+	tracker := newProgressTracker(ctx, progress)
+
+	if len(config.Layers) > 0 {
+		if err := hideLayers(tracker, config, opts, logger); err != nil {
+			return nil, err
+		}
+		report, err := hideReport(config, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Log(OutputSteps, "All done! c:")
+
+		return report, nil
+	}
 
-	printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Loading the image from '%v'...", config.ImagePath))
-	pixels, info, err := loadImage(config.ImagePath, outputLevel)
+	data, archive, err := readPayload(config, logger)
 	if err != nil {
-		printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", config.ImagePath))
-		return err
+		return nil, err
+	}
+	opts = opts.With(withArchive(archive))
+	logger.Log(OutputInfo, fmt.Sprintf("Input file size: %d B", len(data)))
+	if data, opts, err = preparePayload(data, opts, logger); err != nil {
+		return nil, err
 	}
 
-	config.MaxBitsPerChannel = uint8(util.Min(int(config.MaxBitsPerChannel), int(info.Format.BitsPerChannel)))
 
-	printlnLvl(outputLevel, OutputInfo,
-		fmt.Sprintf("Image info:\n\tDimensions: %dx%dpx\n\tColour model: %v\n\tChannels per pixel: %d\n\tBits per channel: %d",
-		info.W, info.H, colourModelToStr(info.Format.Model), info.Format.ChannelsPerPix, info.Format.BitsPerChannel))
+	logger.Log(OutputSteps, "Loading up the pattern key...")
+	pHash, err := opts.patternHash()
+	if err != nil {
+		logger.Log(OutputSteps, "Something went wrong while attempting to load the pattern key.")
+		return nil, err
+	}
+	logger.Log(OutputInfo, "Loaded the pattern key.", "hash", pHash)
+
+
+	if len(config.ImagePaths) > 0 {
+		if err = hideSplit(tracker, config, opts, pHash, data, logger); err != nil {
+			return nil, err
+		}
+	} else {
+		logger.Log(OutputSteps, fmt.Sprintf("Loading the image from '%v'...", config.ImagePath))
+		pixels, info, err := loadImage(config.ImagePath, logger)
+		if err != nil {
+			logger.Log(OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", config.ImagePath))
+			return nil, err
+		}
+		if err = hideInImage(tracker, pixels, info, config.OutPath, opts, pHash, data, partInfo{count: 1, threshold: opts.threshold}, logger); err != nil {
+			return nil, err
+		}
+	}
+	report, err := hideReport(config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+
+	logger.Log(OutputSteps, "All done! c:")
+
+	return report, nil
+}
+
+// Helper functions
+
+// hideReport creates the HideReport of config, once its images have been written.
+func hideReport(config *HideConfig, logger Logger) (*HideReport, error) {
+	report := &HideReport{}
+	if !config.MeasureQuality {
+		return report, nil
+	}
+
+	imagePaths, outPaths := config.ImagePaths, config.OutPaths
+	if len(imagePaths) <= 0 {
+		imagePaths, outPaths = []string{config.ImagePath}, []string{config.OutPath}
+	}
+	quiet := loggerOrNop(nil)
+	for i, imagePath := range imagePaths {
+		logger.Log(OutputSteps, fmt.Sprintf("Measuring the distortion of '%v'...", outPaths[i]))
+		quality, err := CompareImages(imagePath, outPaths[i], quiet)
+		if err != nil {
+			return nil, err
+		}
+		logger.Log(OutputInfo, fmt.Sprintf("PSNR: %.2f dB, SSIM: %.6f, %d of %d channel values changed (by up to %d).",
+			quality.PSNR, quality.SSIM, quality.ChangedChannels, quality.Channels, quality.MaxDelta))
+		report.Quality = append(report.Quality, quality)
+	}
+	return report, nil
+}
+
+// hideSplit splits the stored data across the images of config.ImagePaths, in proportion to how much each of them can
+// hold. If opts has a threshold, each image gets a share of the whole of it instead.
+func hideSplit(tracker *progressTracker, config *HideConfig, opts *Options, pHash int64, data []byte, logger Logger) error {
+	pixelsList := make([]*pixelBuffer, len(config.ImagePaths))
+	infos := make([]imgInfo, len(config.ImagePaths))
+	capacities := make([]int64, len(config.ImagePaths))
+	for i, imagePath := range config.ImagePaths {
+		logger.Log(OutputSteps, fmt.Sprintf("Loading the image from '%v'...", imagePath))
+		pixels, info, err := loadImage(imagePath, logger)
+		if err != nil {
+			logger.Log(OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", imagePath))
+			return err
+		}
+		report, err := imageCapacity(pixels, info, opts, logger)
+		if err != nil {
+			return err
+		}
+		pixelsList[i], infos[i], capacities[i] = pixels, info, report.MaxFileSize
+	}
 
-	printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Opening the file at '%v'...", config.FilePath))
-	fileReader, err := os.Open(config.FilePath)
+	var parts [][]byte
+	var err error
+	if opts.threshold > 0 {
+		for i, c := range capacities {
+			if int64(len(data)) > c {
+				return &InsufficientHidingSpotsError{AdditionalInfo:fmt.Sprintf("Every image needs to hold a share " +
+					"as large as the file (%d B), but '%v' can only hold %d B.", len(data), config.ImagePaths[i], c)}
+			}
+		}
+		if parts, err = makeShares(data, int(opts.threshold), len(capacities)); err != nil {
+			return err
+		}
+	} else if parts, err = splitPayload(data, capacities); err != nil {
+		return err
+	}
+	setID, err := newSetID()
 	if err != nil {
-		printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Unable to open the file at '%v'.", config.FilePath))
 		return err
 	}
+	if opts.threshold > 0 {
+		logger.Log(OutputInfo, fmt.Sprintf("Sharing the file across %d images, any %d of which can dig it up.",
+			len(parts), opts.threshold), "set", setID)
+	} else {
+		logger.Log(OutputInfo, fmt.Sprintf("Splitting the file across %d images.", len(parts)), "set", setID)
+	}
 
-	defer func() {
-		if err = fileReader.Close(); err != nil {
-			printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Error closing the file '%v': %v", config.FilePath, err.Error()))
+	for i, part := range parts {
+		logger.Log(OutputSteps, fmt.Sprintf("Hiding part %d of %d (%d B) in '%v'...", i + 1, len(parts), len(part),
+			config.ImagePaths[i]))
+		err = hideInImage(tracker, pixelsList[i], infos[i], config.OutPaths[i], opts, pHash, part,
+			partInfo{setID, uint8(i), uint8(len(parts)), opts.threshold}, logger)
+		if err != nil {
+			return err
 		}
-	}()
+	}
+	return nil
+}
 
+// hideInImage hides data (which has already been packed and compressed) in a loaded image, and writes the result to
+// outPath.
+func hideInImage(tracker *progressTracker, pixels *pixelBuffer, info imgInfo, outPath string, opts *Options, pHash int64, data []byte, part partInfo, logger Logger) error {
+	if err := embedInImage(tracker, pixels, info, opts, pHash, data, part, logger); err != nil {
+		return err
+	}
 
-	printlnLvl(outputLevel, OutputSteps, "Loading up the pattern key...")
-	pHash, err := hashPatternFile(config.PatternPath)
+	logger.Log(OutputSteps, fmt.Sprintf("Writing the encoded image to '%v' now...", outPath))
+	if err := writeImage(pixels, outPath, logger); err != nil {
+		logger.Log(OutputSteps, "An error occurred while writing to the final image.")
+		return err
+	}
+
+	return nil
+}
+
+// embedInImage hides data (which has already been packed and compressed) in the pixels of a loaded image.
+func embedInImage(tracker *progressTracker, pixels *pixelBuffer, info imgInfo, opts *Options, pHash int64, data []byte, part partInfo, logger Logger) error {
+	bitsPerChannel := uint8(util.Min(int(opts.maxBitsPerChannel), int(info.Format.BitsPerChannel)))
+
+	logger.Log(OutputInfo,
+		fmt.Sprintf("Image info:\n\tDimensions: %dx%dpx\n\tColour model: %v\n\tChannels per pixel: %d\n\tBits per channel: %d",
+		info.W, info.H, colourModelToStr(info.Format.Model), info.Format.ChannelsPerPix, info.Format.BitsPerChannel))
+
+	mask, err := buildMask(opts.maskRects, opts.maskPath, opts.maskExclude, info, logger)
 	if err != nil {
-		printlnLvl(outputLevel, OutputSteps,
-			fmt.Sprintf("Something went wrong while attempting to hash the pattern file '%v'.", config.PatternPath))
 		return err
 	}
-	printlnLvl(outputLevel, OutputInfo, "Pattern hash:", pHash)
+	if mask != nil {
+		logger.Log(OutputInfo, fmt.Sprintf("The mask allows %d of %d pixels to be used.", mask.count(), len(mask)))
+	}
 
+	logger.Log(OutputSteps, "Encoding the file into the image...")
 
-	printlnLvl(outputLevel, OutputSteps, "Encoding the file into the image...")
+	if opts.algorithm == algos.AlgoRobust {
+		return hideRobust(tracker, opts, pixels, info, pHash, data, part, logger)
+	}
 
-	r := bufio.NewReader(fileReader)
-	b := make([]byte, util.Max(int(encodeChunkSize), int(encodeHeaderSize)))
+	b := make([]byte, encodeHeaderSize)
 
 	channelsPerPix := info.Format.ChannelsPerPix
-	if info.Format.supportsAlpha() && !config.EncodeAlpha {
+	if info.Format.supportsAlpha() && !opts.alpha {
 		channelsPerPix--
 	}
 	if channelsPerPix <= 0 { // In the case of Alpha & Alpha16 models
@@ -117,26 +286,60 @@ func Hide(config *HideConfig, outputLevel OutputLevel) error {
 			colourModelToStr(info.Format.Model))}
 	}
 
-	channelCount := int64(len(*pixels)) * int64(channelsPerPix)
-	maxWritableBits := channelCount * int64(config.MaxBitsPerChannel)
-	printlnLvl(outputLevel, OutputInfo, "Maximum writable bits:", maxWritableBits)
+	channelCount := pixels.count() * int64(channelsPerPix)
+	maxWritableBits := channelCount * int64(bitsPerChannel)
+	logger.Log(OutputInfo, "Counted the writable bits of the image.", "bits", maxWritableBits)
 
-	f, err := algos.AlgoAddressor(config.Algorithm, pHash, channelCount, config.MaxBitsPerChannel)
+	f, reseed, err := algos.ReseedableAlgoAddressor(opts.algorithm, pHash, channelCount, bitsPerChannel)
 	if err != nil {
 		return err
 	}
 
+	stream := &bitStream{
+		pixels:         pixels,
+		info:           info,
+		mask:           mask,
+		pos:            f,
+		channels:       channelsPerPix,
+		bitsPerChannel: bitsPerChannel,
+		msb:            opts.msb,
+		// Sequential streams lay the header copies out across the pool, so anything before them is kept clear of them
+		claim:          opts.algorithm == algos.AlgoSequential,
+		logger:         logger,
+	}
+	if opts.algorithm == algos.AlgoPattern && len(opts.recipientPath) <= 0 {
+		if err = reserveKeyHint(stream); err != nil {
+			return err
+		}
+	}
 
-	printlnLvl(outputLevel, OutputSteps, "Writing steg header...")
 
-	fileInfo, err := fileReader.Stat()
-	if err != nil {
-		printlnLvl(outputLevel, OutputSteps, "Unable to retrieve file info!")
-		return err
+	if len(opts.recipientPath) > 0 {
+		logger.Log(OutputSteps, "Writing the ephemeral public key...")
+		session := opts.session
+		if session == nil {
+			if session, err = newKeySession(opts.recipientPath); err != nil {
+				logger.Log(OutputSteps, "Something went wrong while attempting to load the recipient's public key.")
+				return err
+			}
+		}
+		if err = stream.writeBits(*binmani.BytesToBits(session.rep[:])); err != nil {
+			switch err.(type) {
+			case *algos.EmptyPoolError:
+				return &InsufficientHidingSpotsError{InnerError:err}
+			default:
+				return err
+			}
+		}
+		reseed(session.seed)
+		if data, err = sealData(session.key, data); err != nil {
+			return err
+		}
 	}
 
-	//b = []byte(fmt.Sprintf("steg%02d.%02d.%02d%v%019d", VersionMax, VersionMid, VersionMin, encodeHeaderSeparator, fileInfo.Size()))
-	fsize := fileInfo.Size()
+	logger.Log(OutputSteps, "Writing steg header...")
+
+	fsize := int64(len(data))
 	b[0] = VersionMax
 	b[1] = VersionMid
 	b[2] = VersionMin
@@ -144,29 +347,32 @@ func Hide(config *HideConfig, outputLevel OutputLevel) error {
 	b[4] = byte(0xff & (fsize >> 16))
 	b[5] = byte(0xff & (fsize >> 8))
 	b[6] = byte(0xff & fsize)
-	bitsToWrite := fileInfo.Size() * int64(bitsPerByte)
+	copy(b[7:7 + layoutSize], opts.marshalLayout())
+	copy(b[10:10 + partInfoSize], part.marshal())
+	b[17] = opts.HeaderCopies()
+	bitsToWrite := fsize * int64(bitsPerByte)
 
-	printlnLvl(outputLevel, OutputInfo, fmt.Sprintf("Input file size: %d B", fileInfo.Size()))
-	printlnLvl(outputLevel, OutputInfo, "File bits to write:", bitsToWrite)
+	tracker.start(fsize, 0)
+	logger.Log(OutputInfo, "Counted the file bits to write.", "bits", bitsToWrite)
 
 	var eccConfig *bch.EncodingConfig = nil
-	if config.MaxCorrectableErrors > 0 {
-		printlnLvl(outputLevel, OutputSteps, "Setting up data ECC...")
-		chunkBitSize := util.Max(int(encodeChunkSize), int(encodeHeaderSize)) * int(bitsPerByte)
-		codeLength, err := bch.TotalBitsForConfig(chunkBitSize, int(config.MaxCorrectableErrors))
+	if opts.maxCorrectableErrors > 0 {
+		logger.Log(OutputSteps, "Setting up data ECC...")
+		eccConfig, err = createECCConfig(int(encodeChunkSize), opts.maxCorrectableErrors)
 		if err != nil {
 			return err
 		}
-
-		eccConfig, err = bch.CreateConfig(codeLength, int(config.MaxCorrectableErrors))
-		if err != nil {
-			return err
-		}
-		printlnLvl(outputLevel, OutputInfo, fmt.Sprintf("Using a %v. This has a ratio (errors : bits) of %2.2f%%.",
+		logger.Log(OutputInfo, fmt.Sprintf("Using a %v. This has a ratio (errors : bits) of %2.2f%%.",
 			eccConfig, 100 * eccConfig.ECCRatio()))
 
-		bitsToWrite = int64(math.Ceil(float64(bitsToWrite) / float64(eccConfig.StorageBits))) * int64(eccConfig.ChecksumBits()) + bitsToWrite
-		printlnLvl(outputLevel, OutputSteps, "Actual bits to write (including ECC):", bitsToWrite)
+	}
+
+	if eccConfig != nil || opts.recoveryChunks > 0 {
+		bitsToWrite = 0
+		for _, n := range chunkSizes(fsize, opts.recoveryChunks) {
+			bitsToWrite += int64(chunkCodeLength(eccConfig, n))
+		}
+		logger.Log(OutputSteps, "Counted the actual bits to write, including ECC.", "bits", bitsToWrite)
 	}
 
 	if bitsToWrite > maxWritableBits {
@@ -174,123 +380,116 @@ func Hide(config *HideConfig, outputLevel OutputLevel) error {
 			"and the maximum possible with this configuration is %d, there is no way the input file will fit.", bitsToWrite, maxWritableBits)}
 	}
 
-	printlnLvl(outputLevel, OutputDebug, "Encoding header:", string(b[0:]))
+	logger.Log(OutputDebug, "Encoding header.", "header", b[:encodeHeaderSize])
 
-	if err = encodeChunk(config, eccConfig, info, &f, pixels, channelsPerPix, &b, int(encodeHeaderSize), outputLevel); err != nil {
-		switch err.(type) {
-		case *algos.EmptyPoolError:
-			return &InsufficientHidingSpotsError{InnerError:err}
-		default:
-			return err
-		}
+	headerECC, err := createECCConfig(int(encodeHeaderSize), encodeHeaderErrors)
+	if err != nil {
+		return err
 	}
-
-
-	printlnLvl(outputLevel, OutputSteps, "Writing file data...")
-
-	if outputLevel >= OutputDebug {
-		for _, v := range b {
-			fmt.Printf("%#08b\n", v)
-		}
+	headerBits, err := encodeChunk(headerECC, b[:encodeHeaderSize], logger)
+	if err != nil {
+		return err
 	}
-
-	for {
-		n, err := r.Read(b)
-		if n > 0 {
-			if err = encodeChunk(config, eccConfig, info, &f, pixels, channelsPerPix, &b, n, outputLevel); err != nil {
-				switch err.(type) {
-				case *algos.EmptyPoolError:
-					return &InsufficientHidingSpotsError{InnerError:err}
-				default:
-					return err
-				}
-			}
+	for i := 0; i < int(opts.HeaderCopies()); i++ {
+		if opts.algorithm == algos.AlgoSequential {
+			stream.seekHeaderCopy(i, int(opts.HeaderCopies()))
 		}
-		if err != nil {
-			if err != io.EOF {
-				printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("An error occurred while reading the file '%v'.", config.FilePath))
+		if err = stream.writeBits(headerBits); err != nil {
+			switch err.(type) {
+			case *algos.EmptyPoolError:
+				return &InsufficientHidingSpotsError{InnerError:err}
+			default:
 				return err
 			}
-			break
 		}
 	}
 
 
-	printlnLvl(outputLevel, OutputSteps, fmt.Sprintf("Writing the encoded image to '%v' now...", config.OutPath))
-	if err = writeImage(pixels, info, config.OutPath, outputLevel); err != nil {
-		printlnLvl(outputLevel, OutputSteps, "An error occurred while writing to the final image.")
-		return err
+	logger.Log(OutputSteps, "Writing file data...")
+	if opts.algorithm == algos.AlgoSequential {
+		stream.seekData(opts.interleave, bitsToWrite)
 	}
 
+	var chunks [][]byte
+	for pos := 0; pos < len(data); pos += int(encodeChunkSize) {
+		chunks = append(chunks, data[pos:util.Min(pos + int(encodeChunkSize), len(data))])
+	}
 
-	printlnLvl(outputLevel, OutputSteps, "All done! c:")
-
-	return nil
-}
-
-// Helper functions
-
-func encodeChunk(config *HideConfig, eccConfig *bch.EncodingConfig, info imgInfo, pos *func() (int64, error), pixels *[]pixel, channelCount uint8, buf *[]byte, n int, outputLevel OutputLevel) error {
-	supportsAlpha := info.Format.supportsAlpha()
-	alphaChannel := info.Format.alphaChannel()
+	if opts.recoveryChunks > 0 {
+		logger.Log(OutputSteps, "Generating recovery chunks...")
+		chunks = addRecoveryChunks(chunks, opts.recoveryChunks)
+	}
 
-	var writeBits []uint8
-	if eccConfig != nil {
-		dataBits := binmani.BytesToBits((*buf)[:n])
-		printlnLvl(outputLevel, OutputDebug, dataBits)
+	codewords, err := encodeChunks(tracker, eccConfig, chunks, chunkDataBytes(fsize, opts.recoveryChunks), logger)
+	if err != nil {
+		return err
+	}
 
-		if eccConfig.StorageBits < n * int(bitsPerByte) {
-			panic("Provided with a mismatched bch.EncodingConfig for the data to be encoded!")
-		} else if eccConfig.StorageBits > n * int(bitsPerByte) {
-			padBits := make([]uint8, eccConfig.StorageBits - n * int(bitsPerByte))
-			*dataBits = append(*dataBits, padBits...)
-		}
+	if opts.interleave {
+		logger.Log(OutputSteps, "Interleaving the file data across the image...")
+		codewords = [][]uint8{interleave(codewords)}
+	}
 
-		encodedBits, err := bch.Encode(eccConfig, dataBits)
-		if err != nil {
+	for _, codeword := range codewords {
+		if err = tracker.err(); err != nil {
 			return err
 		}
-		writeBits = encodedBits[:n * int(bitsPerByte) + eccConfig.ChecksumBits()]
-		printlnLvl(outputLevel, OutputDebug, writeBits)
-	} else {
-		writeBits = *binmani.BytesToBits((*buf)[:n])
-	}
-
-	n = len(writeBits)
-	for i := 0; i < n; i++ {
-		for {
-			addr, err := (*pos)()
-			if err != nil {
+		if err = stream.writeBits(codeword); err != nil {
+			switch err.(type) {
+			case *algos.EmptyPoolError:
+				return &InsufficientHidingSpotsError{InnerError:err}
+			default:
 				return err
 			}
-			p, c, b := bitAddrToPCB(addr, channelCount, config.MaxBitsPerChannel)
+		}
+	}
 
-			if outputLevel >= OutputDebug {
-				fmt.Printf("addr: %d, pixel: %d, channel: %d, bit: %d, RGBA: %v\n", addr, p, c, b, (*pixels)[p])
-			}
+	return nil
+}
 
-			if supportsAlpha && (*pixels)[p][alphaChannel] <= 0 {
-				continue
-			}
+// encodeChunks encodes each of chunks with encodeChunk, in parallel. dataBytes holds the number of bytes of the file in
+// each chunk, for progress reporting.
+func encodeChunks(tracker *progressTracker, eccConfig *bch.EncodingConfig, chunks [][]byte, dataBytes []int, logger Logger) ([][]uint8, error) {
+	codewords := make([][]uint8, len(chunks))
+	errs := make([]error, len(chunks))
+	err := forEachChunk(tracker, len(chunks), logger, func(i int) {
+		codewords[i], errs[i] = encodeChunk(eccConfig, chunks[i], logger)
+		tracker.add(int64(dataBytes[i]), 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codewords, nil
+}
 
-			if outputLevel >= OutputDebug {
-				fmt.Printf("	Writing %d...\n", writeBits[i])
-				fmt.Printf("	Channel before: %#016b - %v\n", (*pixels)[p][c], (*pixels)[p])
-			}
+// encodeChunk converts a chunk of data into the bits to hide for it, adding ECC bits if eccConfig is set.
+func encodeChunk(eccConfig *bch.EncodingConfig, buf []byte, logger Logger) ([]uint8, error) {
+	n := len(buf)
+	if eccConfig == nil {
+		return *binmani.BytesToBits(buf), nil
+	}
 
-			bitPos := b
-			if config.EncodeMsb {
-				bitPos = info.Format.BitsPerChannel - b - 1
-			}
-			(*pixels)[p][c] = binmani.WriteTo((*pixels)[p][c], bitPos, 1, uint16(writeBits[i]))
+	dataBits := binmani.BytesToBits(buf)
+	logger.Log(OutputDebug, "Encoding chunk.", "bits", *dataBits)
 
-			if outputLevel >= OutputDebug {
-				fmt.Printf("	Channel after:  %#016b - %v\n", (*pixels)[p][c], (*pixels)[p])
-			}
+	if eccConfig.StorageBits < n * int(bitsPerByte) {
+		panic("Provided with a mismatched bch.EncodingConfig for the data to be encoded!")
+	} else if eccConfig.StorageBits > n * int(bitsPerByte) {
+		padBits := make([]uint8, eccConfig.StorageBits - n * int(bitsPerByte))
+		*dataBits = append(*dataBits, padBits...)
+	}
 
-			break
-		}
+	encodedBits, err := bch.Encode(eccConfig, dataBits)
+	if err != nil {
+		return nil, err
 	}
+	writeBits := encodedBits[:n * int(bitsPerByte) + checksumBits(eccConfig)]
+	logger.Log(OutputDebug, "Encoded chunk.", "bits", writeBits)
 
-	return nil
-}
\ No newline at end of file
+	return writeBits, nil
+}
@@ -0,0 +1,55 @@
+package steg
+
+// Interleaving spreads the bits of each codeword across the whole carrier, so that localized damage to the image
+// (cropping, scribbling, etc.) turns into a small number of correctable errors in many chunks, instead of
+// unrecoverable damage to a few. The block interleaver only mixes the chunks together, so with the sequential algorithm
+// the interleaved bits are also laid out stride apart across the whole image (see bitStream.seekData), rather than packed
+// into the start of it - the pattern algorithm scatters them anyway.
+
+// interleave combines codewords with a block interleaver. The codewords are treated as the rows of a matrix, which is
+// then read out column by column. Rows shorter than the longest one (the last chunk of a file) are simply skipped over
+// once they run out.
+func interleave(codewords [][]uint8) []uint8 {
+	maxLength, totalLength := 0, 0
+	for _, codeword := range codewords {
+		totalLength += len(codeword)
+		if len(codeword) > maxLength {
+			maxLength = len(codeword)
+		}
+	}
+
+	bits := make([]uint8, 0, totalLength)
+	for col := 0; col < maxLength; col++ {
+		for _, codeword := range codewords {
+			if col < len(codeword) {
+				bits = append(bits, codeword[col])
+			}
+		}
+	}
+
+	return bits
+}
+
+// deinterleave reverses interleave, splitting bits back into codewords of the provided lengths.
+func deinterleave(bits []uint8, lengths []int) [][]uint8 {
+	maxLength := 0
+	codewords := make([][]uint8, len(lengths))
+	for i, length := range lengths {
+		codewords[i] = make([]uint8, 0, length)
+		if length > maxLength {
+			maxLength = length
+		}
+	}
+
+	pos := 0
+	for col := 0; col < maxLength; col++ {
+		for i, length := range lengths {
+			if col < length {
+				codewords[i] = append(codewords[i], bits[pos])
+				pos++
+			}
+		}
+	}
+
+	return codewords
+}
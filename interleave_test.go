@@ -0,0 +1,125 @@
+package steg
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zedseven/steg/internal/algos"
+)
+
+const (
+	// bandECC is the number of correctable errors per chunk that the damaged-band test hides with.
+	bandECC   = 12
+	// bandStart and bandEnd are the rows of the horizontal band of the image that the damaged-band test scribbles over.
+	bandStart = 8
+	bandEnd   = 14
+)
+
+// Tests
+
+func TestInterleave(t *testing.T) {
+	tests := []struct {
+		name    string
+		lengths []int
+	}{
+		{"no codewords", nil},
+		{"one codeword", []int{7}},
+		{"equal lengths", []int{5, 5, 5}},
+		{"short last codeword", []int{6, 6, 6, 2}},
+		{"uneven lengths", []int{3, 9, 1, 4}},
+		{"empty codeword", []int{4, 0, 4}},
+		{"many codewords", []int{300, 300, 300, 300, 300, 57}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Every bit is tagged with a unique index, split over two sets of codewords since the bits are bytes
+			var low, high [][]uint8
+			total := 0
+			for _, length := range test.lengths {
+				l, h := make([]uint8, length), make([]uint8, length)
+				for j := range l {
+					l[j], h[j] = uint8(total), uint8(total >> 8)
+					total++
+				}
+				low, high = append(low, l), append(high, h)
+			}
+
+			lowBits, highBits := interleave(low), interleave(high)
+			if len(lowBits) != total {
+				t.Fatalf("got %d bits, want %d", len(lowBits), total)
+			}
+			seen := make([]bool, total)
+			for i := range lowBits {
+				index := int(highBits[i]) << 8 | int(lowBits[i])
+				if seen[index] {
+					t.Fatalf("Bit %d was interleaved twice.", index)
+				}
+				seen[index] = true
+			}
+
+			for _, pair := range []struct {
+				bits      []uint8
+				codewords [][]uint8
+			}{{lowBits, low}, {highBits, high}} {
+				got := deinterleave(pair.bits, test.lengths)
+				if len(got) != len(pair.codewords) {
+					t.Fatalf("got %d codewords, want %d", len(got), len(pair.codewords))
+				}
+				for i := range got {
+					if !bytes.Equal(got[i], pair.codewords[i]) {
+						t.Fatalf("Codeword %d came back as %v, want %v", i, got[i], pair.codewords[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestInterleaveDamagedBand checks that a band of the image that is scribbled over loses the file with the sequential
+// algorithm, but is corrected with interleaving, with the same ECC.
+func TestInterleaveDamagedBand(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	imagePath := writeTestImage(t, dir)
+	filePath, data := writeTestFile(t, dir, 1500)
+
+	for _, interleaved := range []bool{false, true} {
+		outPath := filepath.Join(dir, "out.png")
+		opts := NewOptions(WithAlgorithm(algos.AlgoSequential), WithPassphrase("hunter2"), WithECC(bandECC),
+			WithInterleave(interleaved))
+		if _, err := Hide(&HideConfig{ImagePath: imagePath, FilePath: filePath, OutPath: outPath, Options: opts},
+			nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := digTestFile(t, dir, outPath, opts); !bytes.Equal(got, data) {
+			t.Fatalf("Interleaved: %v. The file couldn't be dug up before the image was damaged.", interleaved)
+		}
+
+		hidden := readPNG(t, outPath)
+		damaged := image.NewNRGBA(hidden.Bounds())
+		draw.Draw(damaged, damaged.Rect, hidden, hidden.Bounds().Min, draw.Src)
+		r := rand.New(rand.NewSource(1))
+		for y := bandStart; y < bandEnd; y++ {
+			for x := 0; x < damaged.Rect.Dx(); x++ {
+				i := damaged.PixOffset(x, y)
+				r.Read(damaged.Pix[i:i + 3])
+			}
+		}
+		if err := writePNG(damaged, outPath); err != nil {
+			t.Fatal(err)
+		}
+
+		got := digTestFile(t, dir, outPath, opts)
+		if interleaved && !bytes.Equal(got, data) {
+			t.Error("The file couldn't be dug up with interleaving.")
+		}
+		if !interleaved && bytes.Equal(got, data) {
+			t.Error("The file was dug up without interleaving, so the band doesn't damage enough of it to test with.")
+		}
+	}
+}
@@ -26,6 +26,8 @@ func (algo Algo) String() string {
 		return "sequential"
 	case AlgoPattern:
 		return "pattern"
+	case AlgoRobust:
+		return "robust"
 	default:
 		return "<unknown>"
 	}
@@ -38,6 +40,9 @@ const (
 	AlgoSequential Algo = iota
 	// AlgoPattern is an algorithm that returns unique, random addresses in the range of 0 to Max.
 	AlgoPattern    Algo = iota
+	// AlgoRobust is an algorithm that hides data in the frequency domain of blocks of the image, in a random order.
+	// It trades most of the capacity for surviving JPEG recompression and mild resizing.
+	AlgoRobust     Algo = iota
 	// maxAlgoVal is the maximum algorithm value, used exclusively for validity checking for the Algo type.
 	maxAlgoVal     Algo = iota - 1
 )
@@ -78,18 +83,65 @@ func SequentialAddressor(channels int64, bitsPerChannel uint8) func() (int64, er
 	}
 }
 
+// OffsetSequentialAddressor is SequentialAddressor, except that it starts at start, and wraps around to 0 once it
+// reaches Max.
+func OffsetSequentialAddressor(start, channels int64, bitsPerChannel uint8) func() (int64, error) {
+	posMax := channels * int64(bitsPerChannel)
+	handedOut := int64(0)
+	return func() (int64, error) {
+		if handedOut >= posMax {
+			return -1, &EmptyPoolError{}
+		}
+		pos := (start + handedOut) % posMax
+		handedOut++
+		return pos, nil
+	}
+}
+
+// StridedAddressor hands out every address from 0 to Max once, stride apart. It's SequentialAddressor with the range
+// laid out as the rows of a matrix that's stride addresses wide, and read out column by column instead. A stride of 1
+// is the same as SequentialAddressor.
+func StridedAddressor(stride, channels int64, bitsPerChannel uint8) func() (int64, error) {
+	posMax := channels * int64(bitsPerChannel)
+	if stride < 1 {
+		stride = 1
+	}
+	col, pos := int64(0), int64(-stride)
+	return func() (int64, error) {
+		pos += stride
+		if pos >= posMax {
+			col++
+			pos = col
+		}
+		if col >= stride || pos >= posMax {
+			return -1, &EmptyPoolError{}
+		}
+		return pos, nil
+	}
+}
+
 // PatternAddressor is an algorithm that returns unique, random addresses in the range of 0 to Max.
 func PatternAddressor(seed, channels int64, bitsPerChannel uint8) func() (int64, error) {
+	next, _ := ReseedablePatternAddressor(seed, channels, bitsPerChannel)
+	return next
+}
+
+// ReseedablePatternAddressor is PatternAddressor, except that the seed can be replaced part-way through with reseed.
+// The addresses already handed out are never handed out again, so data placed before and after reseeding can't
+// overlap.
+func ReseedablePatternAddressor(seed, channels int64, bitsPerChannel uint8) (next func() (int64, error), reseed func(seed int64)) {
 	poolSize := channels * int64(bitsPerChannel)
 	pool := util.MakeRange(poolSize)
-	rand.Seed(seed)
+	// Each addressor has its own source, so that several can be used at once (from concurrent Hide/Dig calls)
+	// without affecting each other's address sequences
+	r := rand.New(rand.NewSource(seed))
 	//An implementation of the Fisher-Yates shuffling algorithm, slightly re-purposed
-	return func() (int64, error) {
+	next = func() (int64, error) {
 		if poolSize <= 0 {
 			return -1, &EmptyPoolError{}
 		}
 
-		j := rand.Int63n(poolSize) //I'm aware this isn't crypto/rand, but I needed to be able to seed it
+		j := r.Int63n(poolSize) //I'm aware this isn't crypto/rand, but I needed to be able to seed it
 
 		poolSize--
 
@@ -99,23 +151,73 @@ func PatternAddressor(seed, channels int64, bitsPerChannel uint8) func() (int64,
 		pool = pool[:poolSize]
 
 		return p, nil
-	}//, &pool
+	}
+	reseed = func(seed int64) {
+		r.Seed(seed)
+	}
+	return
+}
+
+// SparsePatternAddressor returns the same addresses as PatternAddressor, but only keeps track of the addresses that
+// have been moved around in the pool, instead of the whole pool. It's cheaper when only a few addresses are needed,
+// and more expensive when most of them are.
+func SparsePatternAddressor(seed, channels int64, bitsPerChannel uint8) func() (int64, error) {
+	poolSize := channels * int64(bitsPerChannel)
+	moved := make(map[int64]int64)
+	r := rand.New(rand.NewSource(seed))
+	at := func(i int64) int64 {
+		if p, ok := moved[i]; ok {
+			return p
+		}
+		return i
+	}
+	return func() (int64, error) {
+		if poolSize <= 0 {
+			return -1, &EmptyPoolError{}
+		}
+
+		j := r.Int63n(poolSize)
+
+		poolSize--
+
+		p := at(j)
+
+		moved[j] = at(poolSize)
+		delete(moved, poolSize)
+
+		return p, nil
+	}
 }
 
 // Algorithm type interfacing methods
 
 // AlgoAddressor facilitates running different algorithm addressors at runtime based on a provided algo value.
+// For AlgoRobust, the addresses are those of the image blocks instead of the channel bits, in a random order.
 func AlgoAddressor(algo Algo, seed, channels int64, bitsPerChannel uint8) (func() (int64, error), error) {
 	switch algo {
 	case AlgoSequential:
 		return SequentialAddressor(channels, bitsPerChannel), nil
-	case AlgoPattern:
+	case AlgoPattern, AlgoRobust:
 		return PatternAddressor(seed, channels, bitsPerChannel), nil
 	default:
 		return nil, &UnknownAlgoError{algo}
 	}
 }
 
+// ReseedableAlgoAddressor is AlgoAddressor, except that the seed can be replaced part-way through with reseed.
+// Reseeding does nothing for AlgoSequential, since it doesn't use a seed.
+func ReseedableAlgoAddressor(algo Algo, seed, channels int64, bitsPerChannel uint8) (func() (int64, error), func(seed int64), error) {
+	switch algo {
+	case AlgoSequential:
+		return SequentialAddressor(channels, bitsPerChannel), func(int64) {}, nil
+	case AlgoPattern, AlgoRobust:
+		next, reseed := ReseedablePatternAddressor(seed, channels, bitsPerChannel)
+		return next, reseed, nil
+	default:
+		return nil, nil, &UnknownAlgoError{algo}
+	}
+}
+
 // StringToAlgo simply parses a string into an algorithm type, or AlgoUnknown if the string is not recognized.
 func StringToAlgo(str string) Algo {
 	switch strings.ToLower(str) {
@@ -123,6 +225,8 @@ func StringToAlgo(str string) Algo {
 		return AlgoSequential
 	case "pattern":
 		return AlgoPattern
+	case "robust":
+		return AlgoRobust
 	default:
 		return AlgoUnknown
 	}
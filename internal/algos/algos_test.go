@@ -0,0 +1,74 @@
+package algos
+
+import (
+	"testing"
+)
+
+// Tests
+
+func TestStridedAddressor(t *testing.T) {
+	tests := []struct {
+		stride, channels int64
+		bitsPerChannel   uint8
+	}{
+		{1, 10, 1},
+		{0, 10, 1},
+		{2, 10, 1},
+		{3, 10, 1},
+		{3, 10, 3},
+		{7, 100, 2},
+		{10, 10, 1},
+		{11, 10, 1},
+		{64, 1000, 3},
+		{1, 0, 1},
+		{5, 0, 1},
+	}
+	for _, test := range tests {
+		poolSize := test.channels * int64(test.bitsPerChannel)
+		next := StridedAddressor(test.stride, test.channels, test.bitsPerChannel)
+
+		seen := make([]bool, poolSize)
+		var prev int64 = -1
+		for i := int64(0); i < poolSize; i++ {
+			addr, err := next()
+			if err != nil {
+				t.Fatalf("stride %d over %d addresses: ran out after %d: %v", test.stride, poolSize, i, err)
+			}
+			if addr < 0 || addr >= poolSize {
+				t.Fatalf("stride %d over %d addresses: handed out %d", test.stride, poolSize, addr)
+			}
+			if seen[addr] {
+				t.Fatalf("stride %d over %d addresses: handed out %d twice", test.stride, poolSize, addr)
+			}
+			seen[addr] = true
+
+			// Within a column, each address is stride after the last one
+			if test.stride > 1 && addr >= test.stride && addr != prev + test.stride {
+				t.Fatalf("stride %d over %d addresses: %d followed %d", test.stride, poolSize, addr, prev)
+			}
+			prev = addr
+		}
+
+		// Once every address has been handed out, the pool stays empty
+		for i := 0; i < 3; i++ {
+			if addr, err := next(); err == nil {
+				t.Fatalf("stride %d over %d addresses: handed out %d past the end", test.stride, poolSize, addr)
+			}
+		}
+	}
+}
+
+func TestStridedAddressorOfOneIsSequential(t *testing.T) {
+	strided := StridedAddressor(1, 50, 2)
+	sequential := SequentialAddressor(50, 2)
+	for {
+		want, wantErr := sequential()
+		got, err := strided()
+		if got != want || (err == nil) != (wantErr == nil) {
+			t.Fatalf("got %d (%v), want %d (%v)", got, err, want, wantErr)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
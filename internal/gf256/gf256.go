@@ -0,0 +1,73 @@
+// Package gf256 implements arithmetic over the Galois field GF(2^8), as used by Reed-Solomon codes and secret sharing.
+package gf256
+
+// The field is generated by the primitive polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11d), with 2 as the generator.
+const primitivePoly = 0x11d
+
+var (
+	expTable [510]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i + 255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x & 0x100 != 0 {
+			x ^= primitivePoly
+		}
+	}
+}
+
+// Add returns a + b. In GF(2^8), addition and subtraction are both XOR.
+func Add(a, b byte) byte {
+	return a ^ b
+}
+
+// Mul returns a * b.
+func Mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a]) + int(logTable[b])]
+}
+
+// Div returns a / b. It panics if b is 0.
+func Div(a, b byte) byte {
+	if b == 0 {
+		panic("gf256: division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a]) + 255 - int(logTable[b])]
+}
+
+// LagrangeWeights returns the weights w such that, for any polynomial p of degree < len(xs),
+// p(x) = w[0] * p(xs[0]) + ... + w[n-1] * p(xs[n-1]). The values of xs must be distinct.
+func LagrangeWeights(xs []byte, x byte) []byte {
+	weights := make([]byte, len(xs))
+	for i, xi := range xs {
+		w := byte(1)
+		for j, xj := range xs {
+			if i == j {
+				continue
+			}
+			w = Mul(w, Div(Add(x, xj), Add(xi, xj)))
+		}
+		weights[i] = w
+	}
+	return weights
+}
+
+// Interpolate evaluates the polynomial of degree < len(xs) passing through the points (xs[i], ys[i]) at x.
+func Interpolate(xs, ys []byte, x byte) byte {
+	var sum byte
+	for i, w := range LagrangeWeights(xs, x) {
+		sum ^= Mul(w, ys[i])
+	}
+	return sum
+}
@@ -0,0 +1,75 @@
+// Package stats implements the statistical functions used by the steganalysis attacks of the package
+// github.com/zedseven/steg.
+package stats
+
+import (
+	"math"
+)
+
+const (
+	// maxIterations is the maximum number of terms evaluated for a series or continued fraction.
+	maxIterations = 1000
+	// epsilon is the relative accuracy that series and continued fractions are evaluated to.
+	epsilon       = 1e-12
+	// tiny is a number near the smallest representable, to avoid dividing by zero in continued fractions.
+	tiny          = 1e-300
+)
+
+// ChiSquareSurvival returns the probability that a chi-square distributed variable with df degrees of freedom is at
+// least x - the p-value of a chi-square test that came out at x.
+func ChiSquareSurvival(x float64, df int) float64 {
+	if df <= 0 {
+		return math.NaN()
+	}
+	if x <= 0 {
+		return 1
+	}
+	return upperGamma(float64(df) / 2, x / 2)
+}
+
+// Helper functions
+
+// upperGamma returns the regularized upper incomplete gamma function Q(a, x), using the series of the lower function
+// for small x and a continued fraction for large x.
+func upperGamma(a, x float64) float64 {
+	lgamma, _ := math.Lgamma(a)
+	prefix := math.Exp(-x + a * math.Log(x) - lgamma)
+
+	if x < a + 1 {
+		sum := 1 / a
+		term := sum
+		for n := 1; n < maxIterations; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum) * epsilon {
+				break
+			}
+		}
+		return math.Max(0, 1 - sum * prefix)
+	}
+
+	// Lentz's method
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < maxIterations; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an * d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an / c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta - 1) < epsilon {
+			break
+		}
+	}
+	return math.Min(1, prefix * h)
+}
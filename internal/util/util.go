@@ -30,4 +30,20 @@ func Max(a, b int) int {
 // This clamps val to the range defined by min and max.
 func Clamp(min, max, val int) int {
 	return Min(max, Max(min, val))
-}
\ No newline at end of file
+}
+
+// Max64 returns the largest of a and b.
+func Max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Min64 returns the smallest of a and b.
+func Min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,226 @@
+// Package x25519 implements the X25519 key agreement (RFC 7748), along with the Elligator 2 map that encodes public
+// keys as strings that are indistinguishable from random bytes.
+//
+// The scalar multiplications are done by golang.org/x/crypto/curve25519, which is constant-time, since they involve
+// private keys. The rest is written with math/big, which is neither fast nor constant-time, but it only ever handles
+// public keys and their representatives.
+package x25519
+
+import (
+	"math/big"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// Size is the size in bytes of scalars, public keys and representatives.
+const Size = 32
+
+var (
+	// p is the prime 2^255 - 19 that the curve is defined over.
+	p         = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	// pMinus2 is used to invert field elements, since x^(p-2) = 1/x.
+	pMinus2   = new(big.Int).Sub(p, big.NewInt(2))
+	// curveA is the coefficient A of the curve v^2 = u^3 + A*u^2 + u.
+	curveA    = big.NewInt(486662)
+	// lowOrder holds the multiples 0 to 7 of a point of order 8, with nil standing for the point at infinity.
+	lowOrder  = func() (points [8]*point) {
+		u, _ := new(big.Int).SetString("39382357235489614581723060781553021112529911719440698176882885853963445705823", 10)
+		points[1] = &point{u, curveV(u)}
+		for t := 2; t < len(points); t++ {
+			points[t] = add(points[t - 1], points[1])
+		}
+		return points
+	}()
+)
+
+// Types
+
+// point is an affine point (u, v) on the curve. The point at infinity is nil.
+type point struct {
+	u, v *big.Int
+}
+
+// ScalarMult returns the u-coordinate of scalar * u. The scalar is clamped as described by RFC 7748, so any 32 random
+// bytes make a valid private key. If u is a point of small order, the result is all zeroes.
+func ScalarMult(scalar, u [Size]byte) [Size]byte {
+	var out [Size]byte
+	if b, err := curve25519.X25519(scalar[:], u[:]); err == nil {
+		copy(out[:], b)
+	}
+	return out
+}
+
+// ScalarBaseMult returns the public key for the private key scalar.
+func ScalarBaseMult(scalar [Size]byte) [Size]byte {
+	return ScalarMult(scalar, [Size]byte{9})
+}
+
+// DirtyScalarBaseMult returns the public key for the private key scalar, with the multiple t (mod 8) of a point of
+// order 8 added to it. Since clamped scalars are multiples of 8, the extra point drops out of any key agreement with
+// it, so the key works just like the one from ScalarBaseMult. Keys from ScalarBaseMult always lie in the prime-order
+// subgroup, which makes their representatives easy to tell apart from random: a key that is to be encoded with
+// Representative must be made with this instead, from a random t.
+func DirtyScalarBaseMult(scalar [Size]byte, t byte) [Size]byte {
+	clean := ScalarBaseMult(scalar)
+	u := decodeElement(clean)
+	// Either of the two points with this u-coordinate will do, since t is random anyway
+	sum := add(&point{u, curveV(u)}, lowOrder[t % 8])
+	if sum == nil {
+		return [Size]byte{}
+	}
+	return encodeElement(sum.u)
+}
+
+// Representative returns the Elligator 2 representative of the public key pub: a string that looks like 32 random
+// bytes, which PublicFromRepresentative turns back into pub. Only about half of all public keys have one, so ok is
+// false for the rest, and a new key should be generated. tweak should be a random byte: its lowest bit picks the sign
+// of the representative, the next one stands in for the sign of the v-coordinate of the key (which only keeps u), and
+// the top one fills in the unused top bit.
+//
+// A key is reached by both branches of the map that PublicFromRepresentative undoes, once through w = u and once
+// through w = -u - A, and which of them a random string goes through is a coin toss. The sign of v picks the branch
+// here the same way, so the representatives go through both of them evenly too.
+//
+// The representatives are only uniform if the keys are uniform over the whole curve, so pub should come from
+// DirtyScalarBaseMult, not from ScalarBaseMult.
+func Representative(pub [Size]byte, tweak byte) (rep [Size]byte, ok bool) {
+	u := decodeElement(pub)
+
+	// u must not be -A or 0, and -2u(u + A) must be a square
+	uPlusA := mod(new(big.Int).Add(u, curveA))
+	if u.Sign() == 0 || uPlusA.Sign() == 0 {
+		return rep, false
+	}
+	check := mod(new(big.Int).Mul(u, uPlusA))
+	check = mod(check.Mul(check, big.NewInt(-2)))
+	if big.Jacobi(check, p) != 1 {
+		return rep, false
+	}
+
+	// r = sqrt(-(u + A) / 2u) goes through w = u, and r = sqrt(-u / (2(u + A))) through w = -u - A
+	num, den := uPlusA, u
+	if tweak & 2 != 0 {
+		num, den = u, uPlusA
+	}
+	r := mod(new(big.Int).Lsh(den, 1))
+	r = mod(r.Mul(new(big.Int).Neg(num), inv(r)))
+	if r.ModSqrt(r, p) == nil {
+		return rep, false
+	}
+	if tweak & 1 != 0 {
+		r = mod(r.Neg(r))
+	}
+	rep = encodeElement(r)
+	rep[Size - 1] |= tweak & 0x80
+	return rep, true
+}
+
+// PublicFromRepresentative returns the public key that the representative rep stands for. Every 32-byte string stands
+// for some public key.
+func PublicFromRepresentative(rep [Size]byte) [Size]byte {
+	r := decodeElement(rep)
+
+	// w = -A / (1 + 2r^2)
+	d := mod(new(big.Int).Mul(r, r))
+	d = mod(d.Add(d.Lsh(d, 1), big.NewInt(1)))
+	w := mod(new(big.Int).Neg(curveA))
+	if d.Sign() != 0 {
+		w = mod(w.Mul(w, inv(d)))
+	}
+
+	// If w^3 + Aw^2 + w is a square, w is on the curve, otherwise -w - A is
+	g := mod(new(big.Int).Add(w, curveA))
+	g = mod(g.Mul(g, w))
+	g = mod(g.Add(g, big.NewInt(1)))
+	g = mod(g.Mul(g, w))
+	if big.Jacobi(g, p) == -1 {
+		w = mod(w.Neg(w).Sub(w, curveA))
+	}
+	return encodeElement(w)
+}
+
+// Helper functions
+
+// curveV returns a v-coordinate of the point on the curve with the u-coordinate u. The other one is its negation.
+func curveV(u *big.Int) *big.Int {
+	v2 := mod(new(big.Int).Add(u, curveA))
+	v2 = mod(v2.Mul(v2, u))
+	v2 = mod(v2.Add(v2, big.NewInt(1)))
+	v2 = mod(v2.Mul(v2, u))
+	if v2.Sign() == 0 {
+		return v2
+	}
+	return new(big.Int).ModSqrt(v2, p)
+}
+
+// add returns a + b, using the affine addition law of the curve.
+func add(a, b *point) *point {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	var lambda *big.Int
+	if a.u.Cmp(b.u) != 0 {
+		// lambda = (v_b - v_a) / (u_b - u_a)
+		lambda = mod(new(big.Int).Sub(b.v, a.v))
+		lambda = mod(lambda.Mul(lambda, inv(mod(new(big.Int).Sub(b.u, a.u)))))
+	} else {
+		if mod(new(big.Int).Add(a.v, b.v)).Sign() == 0 {
+			// b = -a, including the points of order 2 added to themselves
+			return nil
+		}
+		// lambda = (3u^2 + 2Au + 1) / 2v
+		lambda = mod(new(big.Int).Mul(a.u, a.u))
+		lambda = mod(lambda.Mul(lambda, big.NewInt(3)))
+		lambda = mod(lambda.Add(lambda, new(big.Int).Mul(new(big.Int).Lsh(curveA, 1), a.u)))
+		lambda = mod(lambda.Add(lambda, big.NewInt(1)))
+		lambda = mod(lambda.Mul(lambda, inv(mod(new(big.Int).Lsh(a.v, 1)))))
+	}
+
+	// u = lambda^2 - A - u_a - u_b, and v = lambda(u_a - u) - v_a
+	u := mod(new(big.Int).Mul(lambda, lambda))
+	u = mod(u.Sub(u, curveA).Sub(u, a.u).Sub(u, b.u))
+	v := mod(new(big.Int).Sub(a.u, u))
+	v = mod(v.Mul(v, lambda).Sub(v, a.v))
+	return &point{u, v}
+}
+
+// mod reduces x modulo p in place, into the range [0, p).
+func mod(x *big.Int) *big.Int {
+	return x.Mod(x, p)
+}
+
+// inv returns 1 / x modulo p.
+func inv(x *big.Int) *big.Int {
+	return new(big.Int).Exp(x, pMinus2, p)
+}
+
+// decodeElement decodes a little-endian field element, ignoring the top bit.
+func decodeElement(b [Size]byte) *big.Int {
+	b[Size - 1] &= 127
+	return mod(decodeLittleEndian(b))
+}
+
+// decodeLittleEndian decodes a little-endian number.
+func decodeLittleEndian(b [Size]byte) *big.Int {
+	var be [Size]byte
+	for i := range b {
+		be[Size - 1 - i] = b[i]
+	}
+	return new(big.Int).SetBytes(be[:])
+}
+
+// encodeElement encodes a reduced field element in little-endian order.
+func encodeElement(x *big.Int) [Size]byte {
+	var be [Size]byte
+	xb := x.Bytes()
+	copy(be[Size - len(xb):], xb)
+	var b [Size]byte
+	for i := range be {
+		b[i] = be[Size - 1 - i]
+	}
+	return b
+}
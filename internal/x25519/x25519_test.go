@@ -0,0 +1,236 @@
+package x25519
+
+import (
+	"encoding/hex"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+const (
+	// keyCount is the number of random keys that the tests run through.
+	keyCount      = 400
+	// subgroupCount is the number of those keys to check the subgroup of, which is much slower.
+	subgroupCount = 128
+)
+
+// Tests
+
+// TestScalarMult checks ScalarMult against the test vectors of RFC 7748 (sections 5.2 and 6.1).
+func TestScalarMult(t *testing.T) {
+	tests := []struct {
+		scalar, u, want string
+	}{
+		{
+			"a546e36bf0527c9d3b16154b82465edd62144c0ac1fc5a18506a2244ba449ac4",
+			"e6db6867583030db3594c1a424b15f7c726624ec26b3353b10a903a6d0ab1c4c",
+			"c3da55379de9c6908e94ea4df28d084f32eccf03491c71f754b4075577a28552",
+		},
+		{
+			"77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a",
+			"0900000000000000000000000000000000000000000000000000000000000000",
+			"8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a",
+		},
+		{
+			"5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb",
+			"0900000000000000000000000000000000000000000000000000000000000000",
+			"de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f",
+		},
+		{
+			"77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a",
+			"de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f",
+			"4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742",
+		},
+		{
+			"5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb",
+			"8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a",
+			"4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742",
+		},
+	}
+	for _, test := range tests {
+		if got := ScalarMult(decodeHex(t, test.scalar), decodeHex(t, test.u)); got != decodeHex(t, test.want) {
+			t.Errorf("%v * %v is %x, want %v", test.scalar, test.u, got, test.want)
+		}
+	}
+	if got := ScalarBaseMult(decodeHex(t, tests[1].scalar)); got != decodeHex(t, tests[1].want) {
+		t.Errorf("ScalarBaseMult gave %x, want %v", got, tests[1].want)
+	}
+
+	// The iterated test of section 5.2, where each output becomes the next scalar and the scalar the next u
+	k, u := [Size]byte{9}, [Size]byte{9}
+	for i := 1; i <= 1000; i++ {
+		k, u = ScalarMult(k, u), k
+		switch i {
+		case 1:
+			if want := decodeHex(t, "422c8e7a6227d7bca1350b3e2bb7279f7897b87bb6854b783c60e80311ae3079"); k != want {
+				t.Fatalf("After one iteration, got %x, want %x", k, want)
+			}
+		case 1000:
+			if want := decodeHex(t, "684cf59ba83309552800ef566f2f4d3c1c3887c49360e3875f2eb94d99532c51"); k != want {
+				t.Fatalf("After 1000 iterations, got %x, want %x", k, want)
+			}
+		}
+	}
+}
+
+func TestLowOrder(t *testing.T) {
+	if lowOrder[0] != nil {
+		t.Fatal("lowOrder[0] isn't the point at infinity.")
+	}
+	for i := 1; i < len(lowOrder); i++ {
+		if lowOrder[i] == nil {
+			t.Fatalf("lowOrder[%d] is the point at infinity.", i)
+		}
+		if !onCurve(lowOrder[i]) {
+			t.Fatalf("lowOrder[%d] isn't on the curve.", i)
+		}
+	}
+	// The point has order exactly 8: 4 times it is the point of order 2 at (0, 0), and 8 times it is infinity
+	if lowOrder[4].u.Sign() != 0 || lowOrder[4].v.Sign() != 0 {
+		t.Fatalf("lowOrder[4] is (%v, %v), want (0, 0)", lowOrder[4].u, lowOrder[4].v)
+	}
+	if sum := add(lowOrder[7], lowOrder[1]); sum != nil {
+		t.Fatalf("8 times the point is (%v, %v), not infinity", sum.u, sum.v)
+	}
+
+	// Adding any multiple of it to a key doesn't change the secrets agreed with it
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 16; i++ {
+		private, peer := randomKey(r), randomKey(r)
+		pub := ScalarBaseMult(private)
+		u := decodeElement(pub)
+		want := ScalarMult(peer, pub)
+		for j := 1; j < len(lowOrder); j++ {
+			dirty := add(&point{u, curveV(u)}, lowOrder[j])
+			if !onCurve(dirty) {
+				t.Fatalf("Adding lowOrder[%d] left the curve.", j)
+			}
+			if got := ScalarMult(peer, encodeElement(dirty.u)); got != want {
+				t.Fatalf("Adding lowOrder[%d] changed the shared secret.", j)
+			}
+		}
+	}
+}
+
+func TestDirtyScalarBaseMult(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	clean := 0
+	for i := 0; i < keyCount; i++ {
+		private, peer := randomKey(r), randomKey(r)
+		dirty := DirtyScalarBaseMult(private, byte(i))
+		if byte(i) % 8 == 0 && dirty != ScalarBaseMult(private) {
+			t.Fatal("Adding the point at infinity changed the key.")
+		}
+		// Both sides of the agreement have to get the same secret, whichever key the peer sees
+		if ScalarMult(peer, dirty) != ScalarMult(private, ScalarBaseMult(peer)) {
+			t.Fatalf("Key %d doesn't agree on a shared secret.", i)
+		}
+		// Keys in the prime-order subgroup are the ones that the order-8 point doesn't touch
+		if i < subgroupCount && inPrimeOrderSubgroup(dirty) {
+			clean++
+		}
+	}
+	if clean < subgroupCount / 16 || clean > subgroupCount * 3 / 16 {
+		t.Errorf("%d of %d dirty keys are in the prime-order subgroup, want about 1 in 8", clean, subgroupCount)
+	}
+}
+
+func TestRepresentative(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	var represented, squareBranch int
+	for i := 0; i < keyCount; i++ {
+		pub := DirtyScalarBaseMult(randomKey(r), byte(r.Intn(256)))
+		rep, ok := Representative(pub, byte(r.Intn(256)))
+		if !ok {
+			continue
+		}
+		represented++
+		if got := PublicFromRepresentative(rep); got != pub {
+			t.Fatalf("%x has the representative %x, which stands for %x", pub, rep, got)
+		}
+		if throughSquare(rep) {
+			squareBranch++
+		}
+	}
+	if represented < keyCount * 3 / 8 || represented > keyCount * 5 / 8 {
+		t.Errorf("%d of %d keys have a representative, want about half", represented, keyCount)
+	}
+	// Random strings go through either branch of the map half of the time, and representatives have to as well
+	if squareBranch < represented * 3 / 8 || squareBranch > represented * 5 / 8 {
+		t.Errorf("%d of %d representatives go through w = u, want about half", squareBranch, represented)
+	}
+
+	random := 0
+	for i := 0; i < keyCount; i++ {
+		var rep [Size]byte
+		r.Read(rep[:])
+		if throughSquare(rep) {
+			random++
+		}
+	}
+	if random < keyCount * 3 / 8 || random > keyCount * 5 / 8 {
+		t.Errorf("%d of %d random strings go through w = u, want about half", random, keyCount)
+	}
+}
+
+// Helper functions
+
+// decodeHex decodes a 32-byte hex string.
+func decodeHex(t *testing.T, s string) (b [Size]byte) {
+	t.Helper()
+	d, err := hex.DecodeString(s)
+	if err != nil || len(d) != Size {
+		t.Fatalf("'%v' isn't a valid %d-byte hex string.", s, Size)
+	}
+	copy(b[:], d)
+	return
+}
+
+// randomKey returns a random private key.
+func randomKey(r *rand.Rand) (k [Size]byte) {
+	r.Read(k[:])
+	return
+}
+
+// onCurve returns whether a lies on the curve.
+func onCurve(a *point) bool {
+	lhs := mod(new(big.Int).Mul(a.v, a.v))
+	rhs := mod(new(big.Int).Add(a.u, curveA))
+	rhs = mod(rhs.Mul(rhs, a.u))
+	rhs = mod(rhs.Add(rhs, big.NewInt(1)))
+	rhs = mod(rhs.Mul(rhs, a.u))
+	return lhs.Cmp(rhs) == 0
+}
+
+// inPrimeOrderSubgroup returns whether the point with the u-coordinate pub lies in the prime-order subgroup, by
+// checking that the order of the subgroup times it is the point at infinity.
+func inPrimeOrderSubgroup(pub [Size]byte) bool {
+	// l = 2^252 + 27742317777372353535851937790883648493
+	l, _ := new(big.Int).SetString("27742317777372353535851937790883648493", 10)
+	l.Add(l, new(big.Int).Lsh(big.NewInt(1), 252))
+	u := decodeElement(pub)
+	var product *point
+	addend := &point{u, curveV(u)}
+	for i := 0; i < l.BitLen(); i++ {
+		if l.Bit(i) != 0 {
+			product = add(product, addend)
+		}
+		addend = add(addend, addend)
+	}
+	return product == nil
+}
+
+// throughSquare returns whether PublicFromRepresentative decodes rep through the branch where w = u, which is the one
+// where w^3 + Aw^2 + w is a square.
+func throughSquare(rep [Size]byte) bool {
+	r := decodeElement(rep)
+	d := mod(new(big.Int).Mul(r, r))
+	d = mod(d.Add(d.Lsh(d, 1), big.NewInt(1)))
+	w := mod(new(big.Int).Neg(curveA))
+	w = mod(w.Mul(w, inv(d)))
+	g := mod(new(big.Int).Add(w, curveA))
+	g = mod(g.Mul(g, w))
+	g = mod(g.Add(g, big.NewInt(1)))
+	g = mod(g.Mul(g, w))
+	return big.Jacobi(g, p) != -1
+}
@@ -0,0 +1,231 @@
+package steg
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/zedseven/steg/internal/algos"
+)
+
+// Several files can be hidden in the same image as layers, each under its own key (pattern file, passphrase or
+// recipient key), so that digging with one key only ever finds the file hidden under it. Every layer is an ordinary
+// steg payload, placed by the pattern addressor seeded with its own key, so there's no shared index that would give
+// the other layers away.
+//
+// Since the keys are independent, the addresses of the layers collide now and then, and whichever layer is written
+// last wins. The layers are written from the innermost to the outermost, so a layer is only ever damaged by the layers
+// outside of it, and its ECC corrects that damage. The outermost layer (the decoy) is written last, so it always digs
+// up cleanly, and digging any layer reveals nothing about the layers inside of it. Every layer is dug back up from the
+// finished image before it's written, to make sure that none of them were damaged beyond repair.
+//
+// The ephemeral public key of a layer in public-key mode isn't protected by ECC, so every pattern-mode payload leaves
+// the bits that it would take up alone (see reserveKeyHint). That only lines up if the layers address the image the
+// same way, so when one of them is in public-key mode, they all need the same bits, alpha, msb and mask settings.
+//
+// There's much less room for layers than the capacity of the image suggests. The layers outside of a layer overwrite
+// a share of its bits about as large as the share of the capacity that they fill, and about half of those writes
+// change the bit. Every chunk of the layer has to stay within the errors that it can correct, and the worst one sets
+// the limit, so in practice the outer layers can fill about e / (bits per chunk) of the capacity, where e is the
+// errors of the layer. With errors=16, the 32 B chunks take up 400 bits once the ECC is added, so that's about 4%,
+// whatever the size of the layer itself: in an image that can hold 15 KB, a 300 B layer survives 400 B of outer layers
+// but not 800 B. Hide checks that every layer can still be dug up, so it fails rather than writing an image with a
+// damaged layer.
+//
+// The bits of an inner layer should look random, so that the image doesn't give it away statistically - compression
+// and public-key mode both help with that.
+
+// Types
+
+// HideLayer is an additional file to hide in the same image as the main one, under a different key.
+type HideLayer struct {
+	// FilePath is the path on disk to the file to hide. If it's a directory, everything in it is hidden as an archive.
+	FilePath  string
+	// FilePaths is a list of paths on disk to files and directories to hide together as an archive.
+	// If it's set, FilePath is ignored.
+	FilePaths []string
+	// Options are the settings to hide the layer with, which need a key of their own. They're entirely independent of
+	// the Options of the main file.
+	Options   *Options
+}
+
+// layer is a layer that is ready to be hidden.
+type layer struct {
+	data  []byte
+	opts  *Options
+	pHash int64
+}
+
+const (
+	// maxLayerAttempts is the number of times to try hiding the layers with new ephemeral keys, before giving up.
+	maxLayerAttempts int = 64
+)
+
+// Helper functions
+
+// prepareLayers reads, packs and compresses the files of the main layer (from config and opts) and of every one of
+// config.Layers, from the outermost to the innermost.
+func prepareLayers(config *HideConfig, opts *Options, logger Logger) ([]layer, error) {
+	configs := []*HideConfig{config}
+	optsList := []*Options{opts}
+	for i, l := range config.Layers {
+		if len(l.FilePath) <= 0 && len(l.FilePaths) <= 0 {
+			return nil, &InvalidFormatError{fmt.Sprintf("FilePath and FilePaths of layer %d are both empty.", i + 1)}
+		}
+		if l.Options == nil {
+			return nil, &InvalidFormatError{fmt.Sprintf("Layer %d has no Options, so it has no key.", i + 1)}
+		}
+		if err := l.Options.Validate(); err != nil {
+			return nil, err
+		}
+		configs = append(configs, &HideConfig{FilePath: l.FilePath, FilePaths: l.FilePaths})
+		optsList = append(optsList, l.Options)
+	}
+
+	layers := make([]layer, len(configs))
+	seeds := make(map[int64]int)
+	for i, c := range configs {
+		o := optsList[i]
+		if o.algorithm != algos.AlgoPattern {
+			return nil, &InvalidFormatError{fmt.Sprintf("Every layer must use the %v algorithm, but layer %d uses " +
+				"the %v algorithm.", algos.AlgoPattern, i, o.algorithm)}
+		}
+		if len(o.privateKeyPath) > 0 && len(o.recipientPath) <= 0 {
+			return nil, &InvalidFormatError{fmt.Sprintf("Layer %d is in public-key mode, but has no recipient key.", i)}
+		}
+
+		logger.Log(OutputSteps, fmt.Sprintf("Preparing layer %d...", i))
+		data, archive, err := readPayload(c, logger)
+		if err != nil {
+			return nil, err
+		}
+		o = o.With(withArchive(archive))
+		if len(o.recipientPath) > 0 {
+			// The keys are kept, so that the layer can be dug up again to check it
+			session, err := newKeySession(o.recipientPath)
+			if err != nil {
+				logger.Log(OutputSteps, fmt.Sprintf("Something went wrong while attempting to load the recipient's " +
+					"public key of layer %d.", i))
+				return nil, err
+			}
+			o = o.With(withKeySession(session))
+		}
+		if data, o, err = preparePayload(data, o, logger); err != nil {
+			return nil, err
+		}
+		pHash, err := o.patternHash()
+		if err != nil {
+			logger.Log(OutputSteps, fmt.Sprintf("Something went wrong while attempting to load the key of layer %d.", i))
+			return nil, err
+		}
+		if other, ok := seeds[pHash]; ok {
+			return nil, &InvalidFormatError{fmt.Sprintf("Layers %d and %d have the same key, but every layer needs a " +
+				"different one. Only one layer can be in public-key mode.", other, i)}
+		}
+		seeds[pHash] = i
+
+		layers[i] = layer{data, o, pHash}
+	}
+
+	// The other layers only keep the bits of the ephemeral public key free if they address the image the same way
+	for i, l := range layers {
+		if len(l.opts.recipientPath) <= 0 {
+			continue
+		}
+		for j, other := range layers {
+			if !sameBitLayout(l.opts, other.opts) {
+				return nil, &InvalidFormatError{fmt.Sprintf("Layer %d is in public-key mode, so every layer needs the " +
+					"same bits, alpha, msb and mask settings as it, but layer %d has different ones.", i, j)}
+			}
+		}
+	}
+	return layers, nil
+}
+
+// sameBitLayout returns whether a and b place bits in the same places of an image: whether they use the same bits of
+// the same channels of the same pixels.
+func sameBitLayout(a, b *Options) bool {
+	if a.maxBitsPerChannel != b.maxBitsPerChannel || a.alpha != b.alpha || a.msb != b.msb ||
+		a.maskPath != b.maskPath || a.maskExclude != b.maskExclude || len(a.maskRects) != len(b.maskRects) {
+		return false
+	}
+	for i := range a.maskRects {
+		if a.maskRects[i] != b.maskRects[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hideLayers hides every layer of config in the image at config.ImagePath, from the innermost to the outermost, checks
+// that each of them can still be dug up, and writes the result to config.OutPath.
+func hideLayers(tracker *progressTracker, config *HideConfig, opts *Options, logger Logger) error {
+	layers, err := prepareLayers(config, opts, logger)
+	if err != nil {
+		return err
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Loading the image from '%v'...", config.ImagePath))
+	pixels, info, err := loadImage(config.ImagePath, logger)
+	if err = checkLoaded(pixels, err); err != nil {
+		logger.Log(OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", config.ImagePath))
+		return err
+	}
+
+	// The other layers leave the ephemeral public key of a layer in public-key mode alone, but its data can still be
+	// damaged beyond what its ECC can correct. A new ephemeral key places the data elsewhere, so the layers are hidden
+	// again with one
+	original := append([]uint8(nil), pixels.pix...)
+	for attempt := 1; ; attempt++ {
+		for i := len(layers) - 1; i >= 0; i-- {
+			logger.Log(OutputSteps, fmt.Sprintf("Hiding layer %d (%d B)...", i, len(layers[i].data)))
+			if err = embedInImage(tracker, pixels, info, layers[i].opts, layers[i].pHash, layers[i].data,
+				partInfo{count: 1}, logger); err != nil {
+				return err
+			}
+		}
+
+		logger.Log(OutputSteps, "Checking that every layer can still be dug up...")
+		failed, err := checkLayers(tracker, config.ImagePath, pixels, info, layers, logger)
+		if err == nil {
+			break
+		}
+		session := layers[failed].opts.session
+		if session == nil || attempt >= maxLayerAttempts {
+			return &InsufficientHidingSpotsError{AdditionalInfo:fmt.Sprintf("Layer %d was damaged beyond repair by " +
+				"the layers outside of it. Try more ECC for it, or smaller files.", failed), InnerError:err}
+		}
+
+		logger.Log(OutputInfo, fmt.Sprintf("Layer %d was damaged, so the layers will be hidden again with a new " +
+			"ephemeral key for it.", failed))
+		if session, err = newKeySession(layers[failed].opts.recipientPath); err != nil {
+			return err
+		}
+		layers[failed].opts = layers[failed].opts.With(withKeySession(session))
+		copy(pixels.pix, original)
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Writing the encoded image to '%v' now...", config.OutPath))
+	if err = writeImage(pixels, config.OutPath, logger); err != nil {
+		logger.Log(OutputSteps, "An error occurred while writing to the final image.")
+		return err
+	}
+
+	return nil
+}
+
+// checkLayers digs every layer back up from the pixels of the image they were hidden in, and checks that they match.
+// If one doesn't, its index is returned with the error.
+func checkLayers(tracker *progressTracker, imagePath string, pixels *pixelBuffer, info imgInfo, layers []layer, logger Logger) (int, error) {
+	quiet := loggerOrNop(nil)
+	for i, l := range layers {
+		part, err := digFromPixels(newProgressTracker(tracker.ctx, nil), imagePath, pixels, info, l.opts, l.pHash, quiet)
+		if err == nil && !bytes.Equal(part.data, l.data) {
+			err = &InvalidFormatError{"The data that was dug up doesn't match."}
+		}
+		if err != nil {
+			return i, err
+		}
+		logger.Log(OutputInfo, fmt.Sprintf("Layer %d can be dug up, with %d error(s) to correct.", i, part.eccErrors))
+	}
+	return -1, nil
+}
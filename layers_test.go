@@ -0,0 +1,86 @@
+package steg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// layerTestECC is the number of correctable errors per chunk that the layers are hidden with, to absorb the damage done
+// by the layers outside of them.
+const layerTestECC = 16
+
+// Tests
+
+// TestLayers hides a decoy and two layers inside of it, one under a passphrase and one for a recipient key, and digs
+// each of them up with its own key.
+func TestLayers(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	imagePath := writeTestImage(t, dir)
+	publicPath, privatePath := writeTestKeyPair(t, dir, "recipient")
+	_, wrongPrivatePath := writeTestKeyPair(t, dir, "stranger")
+
+	// The layers go from the outermost (the decoy) to the innermost
+	layers := []struct {
+		size              int
+		hideOpts, digOpts *Options
+	}{
+		{150, NewOptions(WithPassphrase("decoy"), WithECC(layerTestECC)), NewOptions(WithPassphrase("decoy"))},
+		{200, NewOptions(WithRecipientKey(publicPath), WithECC(layerTestECC)), NewOptions(WithPrivateKey(privatePath))},
+		{300, NewOptions(WithPassphrase("inner"), WithECC(layerTestECC)), NewOptions(WithPassphrase("inner"))},
+	}
+	data := make([][]byte, len(layers))
+	config := &HideConfig{ImagePath: imagePath, OutPath: filepath.Join(dir, "out.png")}
+	for i, l := range layers {
+		layerDir := filepath.Join(dir, string(rune('a' + i)))
+		if err := os.Mkdir(layerDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		var filePath string
+		filePath, data[i] = writeTestFile(t, layerDir, l.size)
+		if i == 0 {
+			config.FilePath, config.Options = filePath, l.hideOpts
+		} else {
+			config.Layers = append(config.Layers, HideLayer{FilePath: filePath, Options: l.hideOpts})
+		}
+	}
+	if _, err := Hide(config, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, l := range layers {
+		if got := digTestFile(t, dir, config.OutPath, l.digOpts); !bytes.Equal(got, data[i]) {
+			t.Errorf("Layer %d couldn't be dug up with its own key.", i)
+		}
+	}
+
+	// A key that none of the layers were hidden under finds nothing at all
+	for _, opts := range []*Options{NewOptions(WithPassphrase("wrong")), NewOptions(WithPrivateKey(wrongPrivatePath))} {
+		if got := digTestFile(t, dir, config.OutPath, opts); got != nil {
+			t.Errorf("A wrong key dug up %d B.", len(got))
+		}
+	}
+}
+
+// Helper functions
+
+// writeTestKeyPair generates a key pair and writes it to dir, and returns the paths of the public and private keys.
+func writeTestKeyPair(t *testing.T, dir, name string) (publicPath, privatePath string) {
+	t.Helper()
+	publicKey, privateKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath = filepath.Join(dir, name)
+	publicPath = privatePath + ".pub"
+	if err = ioutil.WriteFile(privatePath, MarshalKey(privateKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(publicPath, MarshalKey(publicKey), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return publicPath, privatePath
+}
@@ -0,0 +1,82 @@
+package steg
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Types
+
+// Logger receives the log output of the package. Implementations must be safe to use from several goroutines.
+type Logger interface {
+	// Enabled returns whether anything logged at level would be recorded. It is used to skip building expensive log
+	// records, such as the per-bit debug traces.
+	Enabled(level OutputLevel) bool
+	// Log records msg at level. keyvals holds additional structured fields as alternating keys and values.
+	Log(level OutputLevel, msg string, keyvals ...interface{})
+}
+
+// writerLogger is a Logger that writes each record to an io.Writer as a line of text.
+type writerLogger struct {
+	w     io.Writer
+	level OutputLevel
+	mutex sync.Mutex
+}
+
+// nopLogger is a Logger that discards everything.
+type nopLogger struct {}
+
+// Library methods
+
+// NewLogger creates a Logger that writes everything logged at level or below to w, one record per line. Records are
+// written as the message followed by the structured fields in key=value form.
+func NewLogger(w io.Writer, level OutputLevel) Logger {
+	return &writerLogger{w: w, level: level}
+}
+
+// Enabled returns whether anything logged at level would be written.
+func (l *writerLogger) Enabled(level OutputLevel) bool {
+	return level > OutputNothing && level <= l.level
+}
+
+// Log writes a record to the underlying io.Writer if level is enabled.
+func (l *writerLogger) Log(level OutputLevel, msg string, keyvals ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i + 1 < len(keyvals) {
+			_, _ = fmt.Fprintf(&b, " %v=%v", keyvals[i], keyvals[i + 1])
+		} else {
+			_, _ = fmt.Fprintf(&b, " %v", keyvals[i])
+		}
+	}
+	b.WriteByte('\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, _ = io.WriteString(l.w, b.String())
+}
+
+// Enabled always returns false.
+func (nopLogger) Enabled(OutputLevel) bool {
+	return false
+}
+
+// Log does nothing.
+func (nopLogger) Log(OutputLevel, string, ...interface{}) {}
+
+// Helper functions
+
+// loggerOrNop returns logger, or a Logger that discards everything if it is nil.
+func loggerOrNop(logger Logger) Logger {
+	if logger == nil {
+		return nopLogger{}
+	}
+	return logger
+}
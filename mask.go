@@ -0,0 +1,100 @@
+package steg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+)
+
+// Types
+
+// pixelMask stores whether each pixel of an image (in Pix order) may be used for hiding data.
+// A nil pixelMask allows every pixel.
+type pixelMask []bool
+
+// allows returns whether the pixel at position p is usable according to the mask.
+func (m pixelMask) allows(p int64) bool {
+	return m == nil || m[p]
+}
+
+// count returns the number of pixels that are usable according to the mask.
+func (m pixelMask) count() int64 {
+	c := int64(0)
+	for _, v := range m {
+		if v {
+			c++
+		}
+	}
+	return c
+}
+
+// Primary methods
+
+// buildMask creates a pixelMask for an image from a list of rectangles and/or a greyscale mask image on disk.
+// The selected area is the union of the rectangles and the bright (>= 50% grey) pixels of the mask image.
+// If exclude is set, the selected area is excluded from hiding instead of being the only area used for it.
+// It only ever depends on the provided inputs and the image dimensions, never on the image contents, so the same
+// mask can be rebuilt for extraction. If no rectangles or mask image are provided, a nil mask is returned.
+func buildMask(rects []image.Rectangle, maskPath string, exclude bool, info imgInfo, logger Logger) (pixelMask, error) {
+	if len(rects) <= 0 && len(maskPath) <= 0 {
+		return nil, nil
+	}
+
+	w, h := int(info.W), int(info.H)
+	mask := make(pixelMask, w * h)
+
+	bounds := image.Rectangle{Max: image.Point{X: w, Y: h}}
+	for _, r := range rects {
+		r = r.Canon().Intersect(bounds)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				mask[y * w + x] = true
+			}
+		}
+	}
+
+	if len(maskPath) > 0 {
+		logger.Log(OutputSteps, fmt.Sprintf("Loading the mask image from '%v'...", maskPath))
+		maskFile, err := os.Open(maskPath)
+		if err != nil {
+			logger.Log(OutputSteps, fmt.Sprintf("Unable to open the mask image at '%v'!", maskPath))
+			return nil, err
+		}
+
+		defer func() {
+			if err = maskFile.Close(); err != nil {
+				logger.Log(OutputSteps, fmt.Sprintf("Error closing the file '%v': %v", maskPath, err.Error()))
+			}
+		}()
+
+		maskImg, _, err := image.Decode(maskFile)
+		if err != nil {
+			logger.Log(OutputSteps, "The mask image couldn't be decoded.", "error", err)
+			return nil, err
+		}
+
+		maskBounds := maskImg.Bounds()
+		if maskBounds.Dx() != w || maskBounds.Dy() != h {
+			return nil, &InvalidFormatError{fmt.Sprintf("The mask image is %dx%dpx, but the image is %dx%dpx. " +
+				"They must have the same dimensions.", maskBounds.Dx(), maskBounds.Dy(), w, h)}
+		}
+
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				grey := color.GrayModel.Convert(maskImg.At(maskBounds.Min.X + x, maskBounds.Min.Y + y)).(color.Gray)
+				if grey.Y >= 0x80 {
+					mask[y * w + x] = true
+				}
+			}
+		}
+	}
+
+	if exclude {
+		for i := range mask {
+			mask[i] = !mask[i]
+		}
+	}
+
+	return mask, nil
+}
@@ -0,0 +1,485 @@
+package steg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/zedseven/steg/internal/algos"
+	"github.com/zedseven/steg/internal/util"
+)
+
+// Options are serialized as one "key=value" line per setting, in a fixed order, with the same keys as the flags of
+// the command-line tool. Blank lines and lines starting with '#' are ignored when parsing them.
+//
+// The settings that describe the layout of the hidden data itself (ECC, interleaving, recovery chunks and compression)
+// are also stored in the steg header in a compact binary form, so that they don't have to be provided again to dig it
+// up.
+
+const (
+	// layoutSize is the size in bytes of the layout settings in a steg header.
+	layoutSize             int   = 3
+	// layoutInterleave is the layout flag for Interleave.
+	layoutInterleave       uint8 = 1 << 0
+	// layoutCompressionShift is the position of the Compression algorithm in the layout flags.
+	layoutCompressionShift uint8 = 1
+	// layoutCompression is the mask of the Compression algorithm in the layout flags.
+	layoutCompression      uint8 = 0x7 << layoutCompressionShift
+	// layoutArchive is the layout flag for data that is an archive of several files.
+	layoutArchive          uint8 = 1 << 4
+)
+
+// Types
+
+// Options stores the settings shared by Hide, Dig and Capacity. It is immutable once created - use NewOptions and
+// With to build one.
+type Options struct {
+	algorithm            algos.Algo
+	patternPath          string
+	passphrase           string
+	maxCorrectableErrors uint8
+	maxBitsPerChannel    uint8
+	alpha                bool
+	msb                  bool
+	maskRects            []image.Rectangle
+	maskPath             string
+	maskExclude          bool
+	interleave           bool
+	recoveryChunks       uint8
+	headerCopies         uint8
+	compression          Compression
+	threshold            uint8
+	recipientPath        string
+	privateKeyPath       string
+	archive              bool        // Set by Hide and Dig from the data itself, rather than by the user
+	session              *keySession // Set by Hide to reuse the same public-key mode keys, and to dig with them
+	headerCopiesRead     bool        // Set by Dig once headerCopies has been read from the steg header
+}
+
+// Option sets a setting of an Options while it is being built.
+type Option func(o *Options)
+
+// Library methods
+
+// NewOptions creates an Options from the defaults (AlgoPattern, 1 bit per channel and everything else off) with opts
+// applied on top, in order.
+func NewOptions(opts ...Option) *Options {
+	o := &Options{
+		algorithm:         algos.AlgoPattern,
+		maxBitsPerChannel: 1,
+		headerCopies:      1,
+	}
+	return o.With(opts...)
+}
+
+// With returns a copy of the Options with opts applied on top, in order. The original is left unchanged.
+func (o *Options) With(opts ...Option) *Options {
+	c := *o
+	c.maskRects = append([]image.Rectangle(nil), o.maskRects...)
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// WithAlgorithm sets the algorithm used to choose where data is hidden.
+func WithAlgorithm(algo algos.Algo) Option {
+	return func(o *Options) { o.algorithm = algo }
+}
+
+// WithPatternFile sets the path on disk to the pattern file that keys the algorithm.
+func WithPatternFile(path string) Option {
+	return func(o *Options) { o.patternPath = path }
+}
+
+// WithPassphrase sets a passphrase to key the algorithm with, instead of a pattern file.
+// The passphrase is never serialized.
+func WithPassphrase(passphrase string) Option {
+	return func(o *Options) { o.passphrase = passphrase }
+}
+
+// WithECC sets the number of bit errors to be able to correct for per file chunk. 0 disables bit ECC.
+func WithECC(maxCorrectableErrors uint8) Option {
+	return func(o *Options) { o.maxCorrectableErrors = maxCorrectableErrors }
+}
+
+// WithBits sets the maximum number of bits to use per pixel channel (1-16).
+// The minimum of this and the supported max of the image format is used.
+func WithBits(maxBitsPerChannel uint8) Option {
+	return func(o *Options) { o.maxBitsPerChannel = maxBitsPerChannel }
+}
+
+// WithAlpha sets whether the alpha channel is used.
+func WithAlpha(alpha bool) Option {
+	return func(o *Options) { o.alpha = alpha }
+}
+
+// WithMsb sets whether the most-significant bits are used instead - mostly for debugging.
+func WithMsb(msb bool) Option {
+	return func(o *Options) { o.msb = msb }
+}
+
+// WithMaskRects sets a list of rectangles (in image coordinates) that restricts where data is hidden.
+func WithMaskRects(rects ...image.Rectangle) Option {
+	return func(o *Options) { o.maskRects = append([]image.Rectangle(nil), rects...) }
+}
+
+// WithMaskImage sets the path on disk to a greyscale mask image of the same dimensions as the image.
+// Bright pixels (>= 50% grey) are where data is hidden.
+func WithMaskImage(path string) Option {
+	return func(o *Options) { o.maskPath = path }
+}
+
+// WithMaskExclude sets whether the area selected by the mask rectangles and image is excluded from hiding instead.
+func WithMaskExclude(exclude bool) Option {
+	return func(o *Options) { o.maskExclude = exclude }
+}
+
+// WithInterleave sets whether the bits of each file chunk are spread across the whole image, instead of being kept
+// together. This makes ECC far more effective against localized damage to the image.
+func WithInterleave(interleave bool) Option {
+	return func(o *Options) { o.interleave = interleave }
+}
+
+// WithRecoveryChunks sets the number of recovery chunks to add per stripe of 32 file chunks. Any 32 of the chunks in a
+// stripe are then enough to recover all of it. Lost chunks are detected by their ECC, so this requires ECC.
+// 0 disables recovery chunks.
+func WithRecoveryChunks(recoveryChunks uint8) Option {
+	return func(o *Options) { o.recoveryChunks = recoveryChunks }
+}
+
+// WithHeaderCopies sets the number of copies of the steg header to write, so that a majority vote can be taken across
+// them. 0 is the same as 1. The number is stored in the header itself, so it doesn't need to match when digging, but
+// digging is quicker if it does. With the pattern algorithm the copies land in random places like everything else, and with
+// the sequential one each copy starts at an even share of the way through the image, so that they don't all sit in the
+// same corner.
+func WithHeaderCopies(headerCopies uint8) Option {
+	return func(o *Options) { o.headerCopies = headerCopies }
+}
+
+// WithCompression sets the algorithm the file is compressed with before it's hidden.
+func WithCompression(compression Compression) Option {
+	return func(o *Options) { o.compression = compression }
+}
+
+// WithThreshold sets the number of images needed to dig up a file that is hidden across several of them. If it's above
+// 0, every image gets a share of the whole file instead of a part of it, so that any threshold of them are enough to
+// dig it up, while fewer reveal nothing about it. 0 splits the file across the images instead.
+func WithThreshold(threshold uint8) Option {
+	return func(o *Options) { o.threshold = threshold }
+}
+
+// WithRecipientKey sets the path on disk to the key file of the recipient's public key, to hide a file for them in
+// public-key mode instead of with a pattern file or passphrase.
+func WithRecipientKey(path string) Option {
+	return func(o *Options) { o.recipientPath = path }
+}
+
+// WithPrivateKey sets the path on disk to the key file of the private key to dig up a file that was hidden in
+// public-key mode.
+func WithPrivateKey(path string) Option {
+	return func(o *Options) { o.privateKeyPath = path }
+}
+
+// Algorithm returns the algorithm used to choose where data is hidden.
+func (o *Options) Algorithm() algos.Algo { return o.algorithm }
+
+// PatternPath returns the path on disk to the pattern file that keys the algorithm.
+func (o *Options) PatternPath() string { return o.patternPath }
+
+// ECC returns the number of bit errors to be able to correct for per file chunk.
+func (o *Options) ECC() uint8 { return o.maxCorrectableErrors }
+
+// Bits returns the maximum number of bits to use per pixel channel.
+func (o *Options) Bits() uint8 { return o.maxBitsPerChannel }
+
+// Alpha returns whether the alpha channel is used.
+func (o *Options) Alpha() bool { return o.alpha }
+
+// Msb returns whether the most-significant bits are used instead.
+func (o *Options) Msb() bool { return o.msb }
+
+// MaskRects returns the rectangles that restrict where data is hidden.
+func (o *Options) MaskRects() []image.Rectangle { return append([]image.Rectangle(nil), o.maskRects...) }
+
+// MaskImage returns the path on disk to the mask image.
+func (o *Options) MaskImage() string { return o.maskPath }
+
+// MaskExclude returns whether the masked area is excluded from hiding instead.
+func (o *Options) MaskExclude() bool { return o.maskExclude }
+
+// Interleave returns whether the bits of each file chunk are spread across the whole image.
+func (o *Options) Interleave() bool { return o.interleave }
+
+// RecoveryChunks returns the number of recovery chunks added per stripe of 32 file chunks.
+func (o *Options) RecoveryChunks() uint8 { return o.recoveryChunks }
+
+// HeaderCopies returns the number of copies of the steg header to write.
+func (o *Options) HeaderCopies() uint8 { return uint8(util.Max(int(o.headerCopies), 1)) }
+
+// Compression returns the algorithm the file is compressed with before it's hidden.
+func (o *Options) Compression() Compression { return o.compression }
+
+// Threshold returns the number of images needed to dig up a file that is shared across several of them, or 0 if it's
+// split across them instead.
+func (o *Options) Threshold() uint8 { return o.threshold }
+
+// RecipientKey returns the path on disk to the key file of the recipient's public key.
+func (o *Options) RecipientKey() string { return o.recipientPath }
+
+// PrivateKey returns the path on disk to the key file of the private key to dig with.
+func (o *Options) PrivateKey() string { return o.privateKeyPath }
+
+// Validate checks that the settings are valid and compatible with each other.
+func (o *Options) Validate() error {
+	if !o.algorithm.IsValid() {
+		return &InvalidFormatError{"Algorithm is invalid."}
+	}
+	if o.maxBitsPerChannel < 1 || o.maxBitsPerChannel > 16 {
+		return &InvalidFormatError{fmt.Sprintf("Bits is outside the allowed range of 1-16: Provided %d.", o.maxBitsPerChannel)}
+	}
+	if int(o.recoveryChunks) > maxRecoveryChunks {
+		return &InvalidFormatError{fmt.Sprintf("RecoveryChunks is outside the allowed range of 0-%d: Provided %d.", maxRecoveryChunks, o.recoveryChunks)}
+	}
+	if o.recoveryChunks > 0 && o.maxCorrectableErrors <= 0 && o.algorithm != algos.AlgoRobust {
+		return &InvalidFormatError{"RecoveryChunks requires ECC to be above 0."}
+	}
+	if o.algorithm == algos.AlgoRobust && (len(o.maskRects) > 0 || len(o.maskPath) > 0) {
+		return &InvalidFormatError{"Masks are not supported by the robust algorithm."}
+	}
+	if !o.compression.IsValid() {
+		return &InvalidFormatError{"Compression is invalid."}
+	}
+	if len(o.patternPath) > 0 && len(o.passphrase) > 0 {
+		return &InvalidFormatError{"Only one of a pattern file and a passphrase can be used."}
+	}
+	if o.publicKeyMode() {
+		if len(o.patternPath) > 0 || len(o.passphrase) > 0 {
+			return &InvalidFormatError{"A pattern file or passphrase can't be used in public-key mode."}
+		}
+		if o.algorithm == algos.AlgoRobust {
+			return &InvalidFormatError{"Public-key mode is not supported by the robust algorithm."}
+		}
+	}
+	return nil
+}
+
+// MarshalText serializes the Options into their canonical text form. The passphrase is left out.
+func (o *Options) MarshalText() ([]byte, error) {
+	var b bytes.Buffer
+	_, _ = fmt.Fprintf(&b, "algo=%v\n", o.algorithm)
+	_, _ = fmt.Fprintf(&b, "pattern=%v\n", o.patternPath)
+	_, _ = fmt.Fprintf(&b, "recipient=%v\n", o.recipientPath)
+	_, _ = fmt.Fprintf(&b, "privatekey=%v\n", o.privateKeyPath)
+	_, _ = fmt.Fprintf(&b, "bits=%d\n", o.maxBitsPerChannel)
+	_, _ = fmt.Fprintf(&b, "alpha=%v\n", o.alpha)
+	_, _ = fmt.Fprintf(&b, "msb=%v\n", o.msb)
+	_, _ = fmt.Fprintf(&b, "errors=%d\n", o.maxCorrectableErrors)
+	_, _ = fmt.Fprintf(&b, "interleave=%v\n", o.interleave)
+	_, _ = fmt.Fprintf(&b, "recovery=%d\n", o.recoveryChunks)
+	_, _ = fmt.Fprintf(&b, "headercopies=%d\n", o.HeaderCopies())
+	_, _ = fmt.Fprintf(&b, "compression=%v\n", o.compression)
+	_, _ = fmt.Fprintf(&b, "threshold=%d\n", o.threshold)
+	_, _ = fmt.Fprintf(&b, "mask=%v\n", o.maskPath)
+	for _, r := range o.maskRects {
+		_, _ = fmt.Fprintf(&b, "maskrect=%d,%d,%d,%d\n", r.Min.X, r.Min.Y, r.Max.X, r.Max.Y)
+	}
+	_, _ = fmt.Fprintf(&b, "maskexclude=%v\n", o.maskExclude)
+	return b.Bytes(), nil
+}
+
+// String returns the canonical text form of the Options on a single line.
+func (o *Options) String() string {
+	text, _ := o.MarshalText()
+	return strings.Replace(strings.TrimSpace(string(text)), "\n", " ", -1)
+}
+
+// ParseOptions parses Options from their text form (see MarshalText). Settings that are missing keep their defaults.
+// A passphrase can be given with the "passphrase" key, even though it's never serialized.
+func ParseOptions(text []byte) (*Options, error) {
+	var opts []Option
+	s := bufio.NewScanner(bytes.NewReader(text))
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if len(line) <= 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, &InvalidFormatError{fmt.Sprintf("Line %d of the options is not in the format key=value.", lineNum)}
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		opt, err := parseOption(key, value)
+		if err != nil {
+			return nil, &InvalidFormatError{fmt.Sprintf("Line %d of the options is invalid: %v", lineNum, err.Error())}
+		}
+		opts = append(opts, opt)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return NewOptions(opts...), nil
+}
+
+// ParseRect parses a rectangle in the format "x0,y0,x1,y1".
+func ParseRect(value string) (image.Rectangle, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("the rectangle '%v' is not in the format x0,y0,x1,y1", value)
+	}
+	var coords [4]int
+	for i, part := range parts {
+		coord, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+		coords[i] = coord
+	}
+	return image.Rect(coords[0], coords[1], coords[2], coords[3]), nil
+}
+
+// Helper functions
+
+// parseOption parses a single setting of the text form of Options.
+// Mask rectangles are added to the ones already set, since there can be several of them.
+func parseOption(key, value string) (Option, error) {
+	switch key {
+	case "algo":
+		algo := algos.StringToAlgo(value)
+		if algo == algos.AlgoUnknown {
+			return nil, fmt.Errorf("unknown algorithm '%v'", value)
+		}
+		return WithAlgorithm(algo), nil
+	case "pattern":
+		return WithPatternFile(value), nil
+	case "passphrase":
+		return WithPassphrase(value), nil
+	case "recipient":
+		return WithRecipientKey(value), nil
+	case "privatekey":
+		return WithPrivateKey(value), nil
+	case "compression":
+		c, ok := StringToCompression(value)
+		if !ok {
+			return nil, fmt.Errorf("unknown compression algorithm '%v'", value)
+		}
+		return WithCompression(c), nil
+	case "mask":
+		return WithMaskImage(value), nil
+	case "maskrect":
+		r, err := ParseRect(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(o *Options) { o.maskRects = append(o.maskRects, r) }, nil
+	case "alpha", "msb", "interleave", "maskexclude":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "alpha":
+			return WithAlpha(b), nil
+		case "msb":
+			return WithMsb(b), nil
+		case "interleave":
+			return WithInterleave(b), nil
+		default:
+			return WithMaskExclude(b), nil
+		}
+	case "bits", "errors", "recovery", "headercopies", "threshold":
+		n, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "bits":
+			return WithBits(uint8(n)), nil
+		case "errors":
+			return WithECC(uint8(n)), nil
+		case "recovery":
+			return WithRecoveryChunks(uint8(n)), nil
+		case "threshold":
+			return WithThreshold(uint8(n)), nil
+		default:
+			return WithHeaderCopies(uint8(n)), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown setting '%v'", key)
+	}
+}
+
+// publicKeyMode returns whether the Options are for public-key mode, where the seed comes from a key agreement.
+func (o *Options) publicKeyMode() bool {
+	return len(o.recipientPath) > 0 || len(o.privateKeyPath) > 0
+}
+
+// patternHash returns the seed for the algorithm addressor, from either the passphrase or the pattern file.
+// The sequential algorithm doesn't use one, so 0 is returned for it. In public-key mode, it's the fixed seed that the
+// ephemeral public key is placed with, and the addressor is reseeded after it.
+func (o *Options) patternHash() (int64, error) {
+	if o.algorithm == algos.AlgoSequential {
+		return 0, nil
+	}
+	if o.publicKeyMode() {
+		return publicKeySeed, nil
+	}
+	if len(o.passphrase) > 0 {
+		h := fnv.New64()
+		_, _ = h.Write([]byte(o.passphrase))
+		return int64(h.Sum64()), nil
+	}
+	if len(o.patternPath) <= 0 {
+		return -1, &InvalidFormatError{fmt.Sprintf("The %v algorithm requires a pattern file or a passphrase.", o.algorithm)}
+	}
+	return hashPatternFile(o.patternPath)
+}
+
+// marshalLayout serializes the layout settings for a steg header.
+func (o *Options) marshalLayout() []byte {
+	var flags uint8
+	if o.interleave {
+		flags |= layoutInterleave
+	}
+	flags |= uint8(o.compression) << layoutCompressionShift & layoutCompression
+	if o.archive {
+		flags |= layoutArchive
+	}
+	return []byte{o.maxCorrectableErrors, flags, o.recoveryChunks}
+}
+
+// withArchive sets whether the hidden data is an archive of several files.
+func withArchive(archive bool) Option {
+	return func(o *Options) { o.archive = archive }
+}
+
+// withHeaderCopiesRead sets the number of copies of the steg header to the one that was read from the header itself.
+func withHeaderCopiesRead(headerCopies uint8) Option {
+	return func(o *Options) {
+		o.headerCopies = headerCopies
+		o.headerCopiesRead = true
+	}
+}
+
+// withKeySession sets the public-key mode keys to hide and dig with, instead of generating or recomputing them.
+func withKeySession(session *keySession) Option {
+	return func(o *Options) { o.session = session }
+}
+
+// withLayout returns a copy of the Options with the layout settings from a steg header.
+func (o *Options) withLayout(b []byte) (*Options, error) {
+	c := o.With(WithECC(b[0]), WithInterleave(b[1] & layoutInterleave != 0), WithRecoveryChunks(b[2]),
+		WithCompression(Compression(b[1] & layoutCompression >> layoutCompressionShift)),
+		withArchive(b[1] & layoutArchive != 0))
+	if b[1] &^ (layoutInterleave | layoutCompression | layoutArchive) != 0 {
+		return nil, &BadHeaderError{}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, &BadHeaderError{}
+	}
+	return c, nil
+}
@@ -0,0 +1,175 @@
+package steg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Picking a carrier ranks candidate images by how well they would hide a file. Every candidate is scored on:
+//
+//  - how much of its capacity the file would fill (which also gives the embedding rate: the fraction of the places in
+//    the image that would carry a bit of it),
+//  - its texture (from Stats), since changes to the least-significant bits are much harder to notice in noisy areas,
+//  - and how it looks to Sample Pair Analysis before anything is hidden in it. Steganalysis already misreads clean
+//    images as having a small embedding rate, and embedding rates below that are hard to tell apart from it.
+//
+// The predicted detectability is the embedding rate over the sum of the embedding rate, the texture and the clean
+// estimate, so it's low when the file is small next to the noise the image already has. The score is
+// (1 - detectability) * (1 - fill), from 0 to 1. It's a heuristic for comparing candidates, not a guarantee.
+
+// pickExtensions are the file extensions of the images that PickCarriers looks at in a directory.
+var pickExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true}
+
+// Types
+
+// PickConfig stores the configuration options for PickCarriers.
+type PickConfig struct {
+	// Dir is the path on disk to a directory of candidate images. Files that aren't PNG, JPEG or GIF images are
+	// skipped.
+	Dir        string
+	// ImagePaths is a list of paths on disk to candidate images, on top of the ones in Dir.
+	ImagePaths []string
+	// FilePath is the path on disk to the file to hide. If it's a directory, everything in it is hidden as an archive.
+	FilePath   string
+	// FilePaths is a list of paths on disk to files and directories to hide together as an archive.
+	// If it's set, FilePath is ignored.
+	FilePaths  []string
+	// Options are the settings that the file would be hidden with. If nil, the defaults from NewOptions are used.
+	Options    *Options
+}
+
+// CarrierScore is how well a candidate image would hide a file.
+type CarrierScore struct {
+	// ImagePath is the path on disk to the image.
+	ImagePath     string
+	// Capacity is the capacity of the image, and whether the file fits in it.
+	Capacity      *CapacityReport
+	// Fill is the fraction of the capacity of the image that the file would take up.
+	Fill          float64
+	// Rate is the predicted embedding rate: the fraction of the places in the image that would carry a bit.
+	Rate          float64
+	// Texture is the mean Texture of the colour channels of the image (see ChannelStats).
+	Texture       float64
+	// CleanRate is the mean embedding rate that Sample Pair Analysis estimates for the image as it is.
+	CleanRate     float64
+	// Detectability is the predicted detectability of the file in the image, from 0 to 1.
+	Detectability float64
+	// Score is how well the image would hide the file, from 0 to 1. It's 0 if the file doesn't fit.
+	Score         float64
+}
+
+// Library methods
+
+// PickCarriers scores every candidate image of config for hiding its file, and returns the scores from the best to the
+// worst. Candidates that can't be loaded are skipped.
+func PickCarriers(config *PickConfig, logger Logger) ([]*CarrierScore, error) {
+	logger = loggerOrNop(logger)
+
+	if len(config.FilePath) <= 0 && len(config.FilePaths) <= 0 {
+		return nil, &InvalidFormatError{"FilePath and FilePaths are both empty."}
+	}
+	opts := config.Options
+	if opts == nil {
+		opts = NewOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	candidates := append([]string(nil), config.ImagePaths...)
+	if len(config.Dir) > 0 {
+		files, err := ioutil.ReadDir(config.Dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if !f.IsDir() && pickExtensions[strings.ToLower(filepath.Ext(f.Name()))] {
+				candidates = append(candidates, filepath.Join(config.Dir, f.Name()))
+			}
+		}
+	}
+	if len(candidates) <= 0 {
+		return nil, &InvalidFormatError{"There are no candidate images to pick from."}
+	}
+
+	data, _, err := readPayload(&HideConfig{FilePath: config.FilePath, FilePaths: config.FilePaths}, logger)
+	if err != nil {
+		return nil, err
+	}
+	fileSize := int64(len(data))
+	if data, _, err = preparePayload(data, opts, logger); err != nil {
+		return nil, err
+	}
+	storedSize := int64(len(data))
+	logger.Log(OutputInfo, fmt.Sprintf("The file takes up %d B once it's prepared.", storedSize))
+
+	var scores []*CarrierScore
+	for _, imagePath := range candidates {
+		logger.Log(OutputSteps, fmt.Sprintf("Scoring '%v'...", imagePath))
+		score, err := scoreCarrier(imagePath, opts, fileSize, storedSize, logger)
+		if err != nil {
+			logger.Log(OutputInfo, fmt.Sprintf("Skipping '%v', since it couldn't be scored: %v", imagePath, err.Error()))
+			continue
+		}
+		scores = append(scores, score)
+	}
+	if len(scores) <= 0 {
+		return nil, &InvalidFormatError{"None of the candidate images could be scored."}
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+	return scores, nil
+}
+
+// Helper functions
+
+// scoreCarrier scores the image at imagePath for hiding a file that takes up storedSize bytes once it's prepared.
+func scoreCarrier(imagePath string, opts *Options, fileSize, storedSize int64, logger Logger) (*CarrierScore, error) {
+	quiet := loggerOrNop(nil)
+	pixels, info, err := loadImage(imagePath, quiet)
+	if err = checkLoaded(pixels, err); err != nil {
+		return nil, err
+	}
+	capacity, err := imageCapacity(pixels, info, opts, quiet)
+	if err != nil {
+		return nil, err
+	}
+	capacity.FileSize, capacity.StoredSize = fileSize, storedSize
+	capacity.Fits = storedSize <= capacity.MaxFileSize
+
+	score := &CarrierScore{ImagePath: imagePath, Capacity: capacity}
+	if capacity.UsableBits <= 0 || capacity.MaxFileSize <= 0 {
+		return score, nil
+	}
+
+	// The file fills its share of the places left over after the header
+	score.Fill = float64(storedSize) / float64(capacity.MaxFileSize)
+	score.Rate = (float64(capacity.HeaderBits) + score.Fill * float64(capacity.UsableBits - capacity.HeaderBits)) /
+		float64(capacity.UsableBits)
+
+	stats := imageStats(pixels, info)
+	for _, c := range stats.Channels {
+		score.Texture += c.Texture / float64(len(stats.Channels))
+	}
+	planes, err := channelPlanes(pixels.img)
+	if err != nil {
+		return nil, err
+	}
+	for _, plane := range planes {
+		score.CleanRate += newChannelEstimate(plane.name, spaRate(plane)).Rate / float64(len(planes))
+	}
+
+	if noise := score.Rate + score.Texture + score.CleanRate; noise > 0 {
+		score.Detectability = score.Rate / noise
+	}
+	if capacity.Fits {
+		score.Score = (1 - score.Detectability) * (1 - score.Fill)
+	}
+	logger.Log(OutputInfo, fmt.Sprintf("'%v' scores %.4f.", imagePath, score.Score))
+	return score, nil
+}
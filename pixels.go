@@ -0,0 +1,67 @@
+package steg
+
+import (
+	"image"
+)
+
+// Types
+
+// pixelBuffer provides channel-level access to the pixels of a decoded image, working directly on its raw Pix slice.
+// Nothing is copied out of the image, so the image itself can be encoded again once the changes are made.
+//
+// The Pix slices of the standard library image types store the channels of each pixel next to each other, with
+// multi-byte channel values in big-endian order (https://golang.org/src/image/image.go?s=8222:8528#L380). Images
+// decoded from files always start at (0, 0) and have no padding between rows, so pixel p simply starts at
+// p * channels * bytesPerChannel.
+type pixelBuffer struct {
+	img             image.Image
+	pix             []uint8
+	channels        int
+	bytesPerChannel int
+}
+
+// Primary methods
+
+// newPixelBuffer wraps the raw Pix slice of img, which is in the format described by info.
+func newPixelBuffer(img image.Image, pix []uint8, info fmtInfo) *pixelBuffer {
+	return &pixelBuffer{
+		img:             img,
+		pix:             pix,
+		channels:        int(info.ChannelsPerPix),
+		bytesPerChannel: int(info.bytesPerChannel()),
+	}
+}
+
+// count returns the number of pixels in the buffer.
+func (b *pixelBuffer) count() int64 {
+	return int64(len(b.pix) / (b.channels * b.bytesPerChannel))
+}
+
+// channel returns the value of channel c of pixel p.
+func (b *pixelBuffer) channel(p int64, c uint8) uint16 {
+	i := (int(p) * b.channels + int(c)) * b.bytesPerChannel
+	if b.bytesPerChannel == 1 {
+		return uint16(b.pix[i])
+	}
+	return uint16(b.pix[i]) << bitsPerByte | uint16(b.pix[i + 1])
+}
+
+// setChannel sets the value of channel c of pixel p.
+func (b *pixelBuffer) setChannel(p int64, c uint8, v uint16) {
+	i := (int(p) * b.channels + int(c)) * b.bytesPerChannel
+	if b.bytesPerChannel == 1 {
+		b.pix[i] = uint8(v)
+		return
+	}
+	b.pix[i] = uint8(v >> bitsPerByte)
+	b.pix[i + 1] = uint8(v)
+}
+
+// pixel returns all of the channel values of pixel p. It allocates, so it is only meant for debug output.
+func (b *pixelBuffer) pixel(p int64) []uint16 {
+	ret := make([]uint16, b.channels)
+	for c := range ret {
+		ret[c] = b.channel(p, uint8(c))
+	}
+	return ret
+}
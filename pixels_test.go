@@ -0,0 +1,190 @@
+package steg
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// The benchmarks compare pixelBuffer with the per-pixel conversion that it replaced, which copied every channel value
+// of the image into a slice of its own before anything could be done with it, and copied them all back before the
+// image could be encoded again.
+
+// benchSize is the width and height of the images that the benchmarks run on.
+const benchSize = 512
+
+// Types
+
+// legacyPixels is the per-pixel representation of an image that pixelBuffer replaced.
+type legacyPixels [][]uint16
+
+// benchImage is a random image to benchmark with, in one of the formats that images are decoded into.
+type benchImage struct {
+	name   string
+	img    image.Image
+	pix    []uint8
+	format fmtInfo
+}
+
+// Tests
+
+func TestPixelBufferMatchesLegacy(t *testing.T) {
+	for _, bench := range benchImages() {
+		t.Run(bench.name, func(t *testing.T) {
+			pixels := newPixelBuffer(bench.img, bench.pix, bench.format)
+			legacy := legacyFromPix(bench.pix, bench.format)
+			if int64(len(legacy)) != pixels.count() {
+				t.Fatalf("got %d pixels, want %d", pixels.count(), len(legacy))
+			}
+			for p := range legacy {
+				for c := range legacy[p] {
+					if got := pixels.channel(int64(p), uint8(c)); got != legacy[p][c] {
+						t.Fatalf("channel %d of pixel %d is %d, want %d", c, p, got, legacy[p][c])
+					}
+				}
+			}
+
+			// Writing the same values both ways has to leave the same bytes behind
+			pix := append([]uint8(nil), bench.pix...)
+			written := newPixelBuffer(bench.img, append([]uint8(nil), bench.pix...), bench.format)
+			for p := range legacy {
+				for c := range legacy[p] {
+					legacy[p][c] ^= 1
+					written.setChannel(int64(p), uint8(c), written.channel(int64(p), uint8(c)) ^ 1)
+				}
+			}
+			legacyToPix(pix, legacy, bench.format)
+			for i := range pix {
+				if pix[i] != written.pix[i] {
+					t.Fatalf("byte %d is %d, want %d", i, written.pix[i], pix[i])
+				}
+			}
+		})
+	}
+}
+
+// Benchmarks
+
+func BenchmarkLoad(b *testing.B) {
+	for _, bench := range benchImages() {
+		b.Run(bench.name + "/legacy", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = legacyFromPix(bench.pix, bench.format)
+			}
+		})
+		b.Run(bench.name + "/pixelBuffer", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = newPixelBuffer(bench.img, bench.pix, bench.format)
+			}
+		})
+	}
+}
+
+func BenchmarkChannel(b *testing.B) {
+	for _, bench := range benchImages() {
+		legacy := legacyFromPix(bench.pix, bench.format)
+		pixels := newPixelBuffer(bench.img, bench.pix, bench.format)
+		channels := uint8(bench.format.ChannelsPerPix)
+		b.Run(bench.name + "/legacy", func(b *testing.B) {
+			sum := 0
+			for i := 0; i < b.N; i++ {
+				for p := range legacy {
+					for c := uint8(0); c < channels; c++ {
+						sum += int(legacy[p][c])
+					}
+				}
+			}
+			_ = sum
+		})
+		b.Run(bench.name + "/pixelBuffer", func(b *testing.B) {
+			sum := 0
+			for i := 0; i < b.N; i++ {
+				for p := int64(0); p < pixels.count(); p++ {
+					for c := uint8(0); c < channels; c++ {
+						sum += int(pixels.channel(p, c))
+					}
+				}
+			}
+			_ = sum
+		})
+	}
+}
+
+// BenchmarkWrite flips the least-significant bit of every channel, and includes getting the changes back into the Pix
+// slice of the image, which is what it takes before the image can be encoded.
+func BenchmarkWrite(b *testing.B) {
+	for _, bench := range benchImages() {
+		channels := uint8(bench.format.ChannelsPerPix)
+		b.Run(bench.name + "/legacy", func(b *testing.B) {
+			legacy := legacyFromPix(bench.pix, bench.format)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for p := range legacy {
+					for c := uint8(0); c < channels; c++ {
+						legacy[p][c] ^= 1
+					}
+				}
+				legacyToPix(bench.pix, legacy, bench.format)
+			}
+		})
+		b.Run(bench.name + "/pixelBuffer", func(b *testing.B) {
+			pixels := newPixelBuffer(bench.img, bench.pix, bench.format)
+			for i := 0; i < b.N; i++ {
+				for p := int64(0); p < pixels.count(); p++ {
+					for c := uint8(0); c < channels; c++ {
+						pixels.setChannel(p, c, pixels.channel(p, c) ^ 1)
+					}
+				}
+			}
+		})
+	}
+}
+
+// Helper functions
+
+// benchImages returns a random 8-bit and 16-bit image of benchSize x benchSize px.
+func benchImages() []benchImage {
+	r := rand.New(rand.NewSource(1))
+	rect := image.Rect(0, 0, benchSize, benchSize)
+
+	nrgba := image.NewNRGBA(rect)
+	r.Read(nrgba.Pix)
+	nrgba64 := image.NewNRGBA64(rect)
+	r.Read(nrgba64.Pix)
+
+	return []benchImage{
+		{"NRGBA", nrgba, nrgba.Pix, fmtInfo{color.NRGBAModel, 4, 8}},
+		{"NRGBA64", nrgba64, nrgba64.Pix, fmtInfo{color.NRGBA64Model, 4, 16}},
+	}
+}
+
+// legacyFromPix copies the channel values out of pix into a slice for each pixel.
+func legacyFromPix(pix []uint8, format fmtInfo) legacyPixels {
+	bytesPerChannel := int(format.bytesPerChannel())
+	channels := int(format.ChannelsPerPix)
+	pixels := make(legacyPixels, len(pix) / (channels * bytesPerChannel))
+	for i := range pixels {
+		pixels[i] = make([]uint16, channels)
+		for j := 0; j < channels; j++ {
+			for k := 0; k < bytesPerChannel; k++ {
+				pixels[i][j] <<= bitsPerByte
+				pixels[i][j] |= uint16(pix[(i * channels + j) * bytesPerChannel + k])
+			}
+		}
+	}
+	return pixels
+}
+
+// legacyToPix copies the channel values of pixels back into pix.
+func legacyToPix(pix []uint8, pixels legacyPixels, format fmtInfo) {
+	bytesPerChannel := int(format.bytesPerChannel())
+	channels := int(format.ChannelsPerPix)
+	for i := range pixels {
+		for j := range pixels[i] {
+			for k := 0; k < bytesPerChannel; k++ {
+				pix[(i * channels + j) * bytesPerChannel + k] = uint8(pixels[i][j] >> (uint8(bytesPerChannel - 1 - k) * bitsPerByte))
+			}
+		}
+	}
+}
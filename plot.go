@@ -0,0 +1,104 @@
+package steg
+
+import (
+	"image"
+	"image/color"
+)
+
+const (
+	// plotWidth is the width in pixels of the area that a plot's curve is drawn in.
+	plotWidth  int = 800
+	// plotHeight is the height in pixels of the area that a plot's curve is drawn in.
+	plotHeight int = 300
+	// plotMargin is the space in pixels around the area of a plot.
+	plotMargin int = 20
+)
+
+var (
+	plotBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	plotAxis       = color.RGBA{0x40, 0x40, 0x40, 0xff}
+	plotGrid       = color.RGBA{0xd8, 0xd8, 0xd8, 0xff}
+	plotCurve      = color.RGBA{0xd0, 0x20, 0x20, 0xff}
+)
+
+// Library methods
+
+// PlotCurve renders values, which are between 0 and 1 (such as probabilities), as a line plot, and writes it to outPath
+// as a PNG. The values are spread evenly across the width of the plot, with 0 at the bottom and 1 at the top, and there
+// are grid lines at every quarter.
+func PlotCurve(values []float64, outPath string) error {
+	img := image.NewRGBA(image.Rect(0, 0, plotWidth + 2 * plotMargin, plotHeight + 2 * plotMargin))
+	fillRect(img, img.Bounds(), plotBackground)
+
+	x0, y0 := plotMargin, plotMargin + plotHeight
+	for q := 1; q <= 4; q++ {
+		y := y0 - q * plotHeight / 4
+		drawLine(img, x0, y, x0 + plotWidth, y, plotGrid)
+	}
+	drawLine(img, x0, plotMargin, x0, y0, plotAxis)
+	drawLine(img, x0, y0, x0 + plotWidth, y0, plotAxis)
+
+	plotPoint := func(i int) (int, int) {
+		x := x0
+		if len(values) > 1 {
+			x += i * plotWidth / (len(values) - 1)
+		}
+		v := values[i]
+		if v < 0 || v != v {
+			v = 0
+		} else if v > 1 {
+			v = 1
+		}
+		return x, y0 - int(v * float64(plotHeight) + 0.5)
+	}
+	for i := range values {
+		x, y := plotPoint(i)
+		if i == 0 {
+			drawLine(img, x, y, x, y, plotCurve)
+			continue
+		}
+		px, py := plotPoint(i - 1)
+		drawLine(img, px, py, x, y, plotCurve)
+	}
+
+	return writePNG(img, outPath)
+}
+
+// Helper functions
+
+// fillRect fills the rectangle r of img with c.
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// drawLine draws a line from (x0, y0) to (x1, y1) on img, with Bresenham's algorithm.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, sx := x1 - x0, 1
+	if dx < 0 {
+		dx, sx = -dx, -1
+	}
+	dy, sy := y1 - y0, 1
+	if dy < 0 {
+		dy, sy = -dy, -1
+	}
+	e := dx - dy
+	for {
+		img.SetRGBA(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 > -dy {
+			e -= dy
+			x0 += sx
+		}
+		if e2 < dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package steg
+
+import (
+	"context"
+	"sync"
+)
+
+// Types
+
+// Progress describes how far along a Hide or Dig operation is.
+type Progress struct {
+	// BytesProcessed is the number of bytes of the file that have been hidden or dug up so far.
+	BytesProcessed  int64
+	// TotalBytes is the size of the file in bytes. When digging, it is 0 until the steg header has been read, and if the
+	// file was split across several images, it grows as the header of each of them is read.
+	TotalBytes      int64
+	// CorrectedErrors is the number of bit errors that have been corrected by ECC so far. It is always 0 when hiding.
+	CorrectedErrors int
+}
+
+// ProgressFunc receives progress updates from HideContext and DigContext, once for each chunk of the file.
+// It may be called from several goroutines, but never from more than one at a time.
+type ProgressFunc func(progress Progress)
+
+// progressTracker keeps track of the progress of an operation and reports it. A nil *progressTracker does nothing.
+type progressTracker struct {
+	ctx      context.Context
+	report   ProgressFunc
+	mutex    sync.Mutex
+	progress Progress
+}
+
+// Primary methods
+
+// newProgressTracker creates a progressTracker for ctx that reports to report, which may be nil.
+func newProgressTracker(ctx context.Context, report ProgressFunc) *progressTracker {
+	return &progressTracker{ctx: ctx, report: report}
+}
+
+// err returns the error of the context, if it has been cancelled.
+func (t *progressTracker) err() error {
+	if t == nil {
+		return nil
+	}
+	return t.ctx.Err()
+}
+
+// start adds the size of the file (or of the part of it in one image) to the total, along with the number of errors
+// that were already corrected in the header.
+func (t *progressTracker) start(totalBytes int64, correctedErrors int) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.progress.TotalBytes += totalBytes
+	t.progress.CorrectedErrors += correctedErrors
+}
+
+// add records that bytes more bytes of the file have been processed, with correctedErrors more errors corrected.
+func (t *progressTracker) add(bytes int64, correctedErrors int) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.progress.BytesProcessed += bytes
+	t.progress.CorrectedErrors += correctedErrors
+	if t.report != nil {
+		t.report(t.progress)
+	}
+}
@@ -0,0 +1,222 @@
+package steg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+
+	"github.com/zedseven/steg/internal/algos"
+	"github.com/zedseven/steg/internal/x25519"
+)
+
+// In public-key mode, a file is hidden for a recipient with their X25519 public key, instead of with a pattern file
+// that both sides need to have. For every image, Hide generates an ephemeral key pair and agrees on a shared secret
+// with the recipient's public key, from which both the seed of the algorithm and an AES-256-GCM key are derived with
+// HKDF-SHA256. The ephemeral public key is written first, in its Elligator 2 encoding so that its bits look random
+// (with a random point of order 8 added to it, since keys in the prime-order subgroup have telltale encodings),
+// at addresses chosen with a fixed seed that anyone can compute. The addressor is then reseeded with the derived seed,
+// and the steg header and the encrypted data follow as usual. The recipient digs the file up with their private key,
+// which recomputes the same shared secret from the ephemeral public key.
+//
+// Key files hold a single key, encoded in base64.
+
+const (
+	// KeySize is the size in bytes of public and private keys.
+	KeySize        int   = x25519.Size
+	// keyHintBits is the number of bits of the image taken up by the ephemeral public key.
+	keyHintBits    int   = x25519.Size * int(bitsPerByte)
+	// sealOverhead is the number of bytes that encryption adds to the data of each image.
+	sealOverhead   int64 = 16
+	// publicKeyLabel is mixed into every key derivation, so that the keys are specific to this use.
+	publicKeyLabel       = "steg public-key mode v1"
+)
+
+// publicKeySeed is the fixed seed used to place the ephemeral public key.
+var publicKeySeed = func() int64 {
+	h := fnv.New64()
+	_, _ = h.Write([]byte(publicKeyLabel))
+	return int64(h.Sum64())
+}()
+
+// Types
+
+// keySession holds the keys of one image hidden in public-key mode.
+type keySession struct {
+	// rep is the representative of the ephemeral public key, which is hidden in the image.
+	rep  [KeySize]byte
+	seed int64
+	key  []byte
+}
+
+// Library methods
+
+// GenerateKeyPair generates a new X25519 key pair for public-key mode.
+func GenerateKeyPair() (publicKey, privateKey [KeySize]byte, err error) {
+	if _, err = rand.Read(privateKey[:]); err != nil {
+		return
+	}
+	publicKey = x25519.ScalarBaseMult(privateKey)
+	return
+}
+
+// MarshalKey encodes a key into the text form used by key files.
+func MarshalKey(key [KeySize]byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(key[:]) + "\n")
+}
+
+// ReadKeyFile reads a key from the key file at path (see MarshalKey).
+func ReadKeyFile(path string) (key [KeySize]byte, err error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	b, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(text)))
+	if err != nil || len(b) != KeySize {
+		return key, &InvalidFormatError{fmt.Sprintf("The key file '%v' does not hold a %d-byte base64 key.", path,
+			KeySize)}
+	}
+	copy(key[:], b)
+	return key, nil
+}
+
+// Helper functions
+
+// newKeySession generates an ephemeral key pair whose public key has an Elligator 2 representative, and agrees on a
+// shared secret with the recipient whose public key is in the key file at recipientPath.
+func newKeySession(recipientPath string) (*keySession, error) {
+	recipient, err := ReadKeyFile(recipientPath)
+	if err != nil {
+		return nil, err
+	}
+	rep, seed, key, err := newEphemeralKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+	return &keySession{rep, seed, key}, nil
+}
+
+// newEphemeralKey generates an ephemeral key pair whose public key has an Elligator 2 representative, and agrees on a
+// shared secret with the recipient. It returns the representative, to be hidden, and the keys derived from the secret.
+// The public key is made dirty (see x25519.DirtyScalarBaseMult), since the representatives of keys in the prime-order
+// subgroup can be told apart from random bytes.
+func newEphemeralKey(recipient [KeySize]byte) (rep [KeySize]byte, seed int64, key []byte, err error) {
+	for {
+		var private [KeySize]byte
+		// One byte picks the representative and its branch and fills its top bit, the other the point of order 8 to add
+		var tweak [2]byte
+		if _, err = rand.Read(private[:]); err != nil {
+			return
+		}
+		if _, err = rand.Read(tweak[:]); err != nil {
+			return
+		}
+		var ok bool
+		if rep, ok = x25519.Representative(x25519.DirtyScalarBaseMult(private, tweak[1]), tweak[0]); !ok {
+			continue
+		}
+		seed, key, err = deriveKeys(x25519.ScalarMult(private, recipient), rep, recipient)
+		return
+	}
+}
+
+// openEphemeralKey recomputes the keys that newEphemeralKey derived, from the representative of the ephemeral public
+// key and the recipient's private key.
+func openEphemeralKey(rep, private [KeySize]byte) (seed int64, key []byte, err error) {
+	shared := x25519.ScalarMult(private, x25519.PublicFromRepresentative(rep))
+	return deriveKeys(shared, rep, x25519.ScalarBaseMult(private))
+}
+
+// reserveKeyHint makes a pattern-mode stream skip over the bit addresses that the ephemeral public key of a layer in
+// public-key mode would take up in it, so that the key is never damaged by the layers around it. The ephemeral public
+// key isn't protected by ECC, so even a single damaged bit would make the layer impossible to dig up. It's done for
+// every stream that isn't in public-key mode itself, since there's no way to tell whether an image has layers.
+func reserveKeyHint(s *bitStream) error {
+	hint := *s
+	hint.pos = algos.SparsePatternAddressor(publicKeySeed, s.pixels.count() * int64(s.channels), s.bitsPerChannel)
+	hint.reserved = nil
+	hint.logger = nopLogger{}
+
+	reserved := make(map[int64]bool, keyHintBits)
+	for len(reserved) < keyHintBits {
+		addr, _, _, _, err := hint.next()
+		if err != nil {
+			if _, ok := err.(*algos.EmptyPoolError); ok {
+				break
+			}
+			return err
+		}
+		reserved[addr] = true
+	}
+	s.reserved = reserved
+	return nil
+}
+
+// deriveKeys derives the seed of the algorithm and the encryption key from a shared secret with HKDF-SHA256 (RFC 5869).
+// The ephemeral and recipient public keys are bound into it as well.
+func deriveKeys(shared, rep, recipient [KeySize]byte) (seed int64, key []byte, err error) {
+	if shared == [KeySize]byte{} {
+		return 0, nil, &InvalidFormatError{"The public key is not valid for key agreement."}
+	}
+
+	extract := hmac.New(sha256.New, nil)
+	_, _ = extract.Write(shared[:])
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	_, _ = expand.Write([]byte(publicKeyLabel))
+	_, _ = expand.Write(rep[:])
+	_, _ = expand.Write(recipient[:])
+	_, _ = expand.Write([]byte{1})
+	okm := expand.Sum(nil)
+	expand.Reset()
+	_, _ = expand.Write(okm)
+	_, _ = expand.Write([]byte(publicKeyLabel))
+	_, _ = expand.Write(rep[:])
+	_, _ = expand.Write(recipient[:])
+	_, _ = expand.Write([]byte{2})
+	okm = expand.Sum(okm)
+
+	for _, b := range okm[32:40] {
+		seed = seed << 8 | int64(b)
+	}
+	return seed, okm[:32], nil
+}
+
+// newAEAD creates the AES-256-GCM cipher for key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealData encrypts and authenticates the data of an image. Every key is only ever used once, so the nonce is fixed.
+func sealData(key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), data, nil), nil
+}
+
+// openData reverses sealData.
+func openData(key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	out, err := aead.Open(nil, make([]byte, aead.NonceSize()), data, nil)
+	if err != nil {
+		return nil, &InvalidFormatError{"The hidden data could not be decrypted. The image is either too damaged, or " +
+			"it was hidden for a different key."}
+	}
+	return out, nil
+}
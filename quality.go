@@ -0,0 +1,195 @@
+package steg
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// The quality metrics measure how much an image was distorted by hiding data in it, by comparing every channel value
+// (including alpha) of the original image to the one in the modified image. Values are compared on the scale of the
+// images, so 0-255 for 8-bit images, and 0-65535 for 16-bit ones. Images of different colour models are both
+// converted to NRGBA first (16-bit if either of them is).
+
+const (
+	// ssimWindow is the width and height in pixels of the windows that SSIM is computed over.
+	ssimWindow int = 8
+	// ssimStep is the number of pixels between the starts of consecutive SSIM windows.
+	ssimStep   int = 4
+)
+
+// Types
+
+// QualityReport describes how different two images are.
+type QualityReport struct {
+	// Channels is the number of channel values that were compared.
+	Channels        int64
+	// ChangedChannels is the number of channel values that are different.
+	ChangedChannels int64
+	// MaxDelta is the largest absolute difference between two channel values.
+	MaxDelta        uint16
+	// MSE is the mean squared error of the channel values.
+	MSE             float64
+	// PSNR is the peak signal-to-noise ratio in decibels. It's +Inf if the images are identical.
+	PSNR            float64
+	// SSIM is the mean structural similarity index of the channels, from -1 to 1, where 1 means the images are
+	// identical.
+	SSIM            float64
+}
+
+// HideReport describes the result of Hide.
+type HideReport struct {
+	// Quality holds a QualityReport for each image that was written, in the same order as OutPaths (or just the one for
+	// OutPath). It's only filled in if HideConfig.MeasureQuality is set.
+	Quality []*QualityReport
+}
+
+// Library methods
+
+// CompareImages measures how different the images at aPath and bPath are, which must have the same dimensions.
+func CompareImages(aPath, bPath string, logger Logger) (*QualityReport, error) {
+	logger = loggerOrNop(logger)
+
+	a, b, info, err := loadComparison(aPath, bPath, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log(OutputSteps, "Comparing the images...")
+	return compareImages(a, b, info), nil
+}
+
+// Helper functions
+
+// loadComparison loads the images at aPath and bPath, converting them both to NRGBA if their colour models differ.
+func loadComparison(aPath, bPath string, logger Logger) (a, b *pixelBuffer, info imgInfo, err error) {
+	if len(aPath) <= 0 || len(bPath) <= 0 {
+		return nil, nil, info, &InvalidFormatError{"Two images are needed to compare."}
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Loading the image from '%v'...", aPath))
+	a, info, err = loadImage(aPath, logger)
+	if err = checkLoaded(a, err); err != nil {
+		logger.Log(OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", aPath))
+		return
+	}
+	logger.Log(OutputSteps, fmt.Sprintf("Loading the image from '%v'...", bPath))
+	b, bInfo, err := loadImage(bPath, logger)
+	if err = checkLoaded(b, err); err != nil {
+		logger.Log(OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", bPath))
+		return
+	}
+
+	if info.W != bInfo.W || info.H != bInfo.H {
+		return nil, nil, info, &InvalidFormatError{fmt.Sprintf("The images have different dimensions (%dx%dpx and " +
+			"%dx%dpx).", info.W, info.H, bInfo.W, bInfo.H)}
+	}
+	if info.Format != bInfo.Format {
+		deep := info.Format.BitsPerChannel > 8 || bInfo.Format.BitsPerChannel > 8
+		logger.Log(OutputInfo, fmt.Sprintf("The images have different colour models (%v and %v), so they're both " +
+			"converted to NRGBA.", colourModelToStr(info.Format.Model), colourModelToStr(bInfo.Format.Model)))
+		if a, info, err = toNRGBA(a.img, deep); err != nil {
+			return
+		}
+		if b, _, err = toNRGBA(b.img, deep); err != nil {
+			return
+		}
+	}
+	return a, b, info, nil
+}
+
+// toNRGBA converts img to 8-bit NRGBA, or to 16-bit NRGBA if deep is set.
+func toNRGBA(img image.Image, deep bool) (*pixelBuffer, imgInfo, error) {
+	var nimg draw.Image = image.NewNRGBA(img.Bounds())
+	if deep {
+		nimg = image.NewNRGBA64(img.Bounds())
+	}
+	draw.Draw(nimg, nimg.Bounds(), img, img.Bounds().Min, draw.Src)
+	return pixelsFromImage(nimg)
+}
+
+// compareImages measures how different the pixels of a and b are. They must have the same dimensions and format.
+func compareImages(a, b *pixelBuffer, info imgInfo) *QualityReport {
+	report := &QualityReport{}
+	channels := info.Format.ChannelsPerPix
+	sum := float64(0)
+	for p := int64(0); p < a.count(); p++ {
+		for c := uint8(0); c < channels; c++ {
+			av, bv := a.channel(p, c), b.channel(p, c)
+			delta := av - bv
+			if bv > av {
+				delta = bv - av
+			}
+			if delta > 0 {
+				report.ChangedChannels++
+				if delta > report.MaxDelta {
+					report.MaxDelta = delta
+				}
+				sum += float64(delta) * float64(delta)
+			}
+			report.Channels++
+		}
+	}
+
+	peak := float64(uint32(1) << info.Format.BitsPerChannel - 1)
+	if report.Channels > 0 {
+		report.MSE = sum / float64(report.Channels)
+	}
+	if report.MSE > 0 {
+		report.PSNR = 10 * math.Log10(peak * peak / report.MSE)
+	} else {
+		report.PSNR = math.Inf(1)
+	}
+
+	ssim := float64(0)
+	for c := uint8(0); c < channels; c++ {
+		ssim += channelSSIM(a, b, info, c, peak)
+	}
+	report.SSIM = ssim / float64(channels)
+
+	return report
+}
+
+// channelSSIM computes the mean SSIM of a channel of a and b, over windows of ssimWindow x ssimWindow pixels that are
+// ssimStep pixels apart. Images smaller than a window are treated as a single window.
+func channelSSIM(a, b *pixelBuffer, info imgInfo, c uint8, peak float64) float64 {
+	w, h := int(info.W), int(info.H)
+	c1 := (0.01 * peak) * (0.01 * peak)
+	c2 := (0.03 * peak) * (0.03 * peak)
+	winW, winH := ssimWindow, ssimWindow
+	if w < winW {
+		winW = w
+	}
+	if h < winH {
+		winH = h
+	}
+	if winW <= 0 || winH <= 0 {
+		return 1
+	}
+
+	total, windows := float64(0), 0
+	for y0 := 0; y0 + winH <= h; y0 += ssimStep {
+		for x0 := 0; x0 + winW <= w; x0 += ssimStep {
+			var sa, sb, saa, sbb, sab float64
+			for y := y0; y < y0 + winH; y++ {
+				for x := x0; x < x0 + winW; x++ {
+					p := int64(y * w + x)
+					av, bv := float64(a.channel(p, c)), float64(b.channel(p, c))
+					sa += av
+					sb += bv
+					saa += av * av
+					sbb += bv * bv
+					sab += av * bv
+				}
+			}
+			n := float64(winW * winH)
+			ma, mb := sa / n, sb / n
+			va, vb := saa / n - ma * ma, sbb / n - mb * mb
+			cov := sab / n - ma * mb
+			total += (2 * ma * mb + c1) * (2 * cov + c2) / ((ma * ma + mb * mb + c1) * (va + vb + c2))
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
@@ -0,0 +1,223 @@
+package steg
+
+import (
+	"fmt"
+
+	"github.com/zedseven/steg/internal/gf256"
+	"github.com/zedseven/steg/internal/util"
+)
+
+// Recovery chunks are an outer Reed-Solomon code over the (BCH-protected) file chunks. The data chunks are split into
+// stripes of up to recoveryStripeSize chunks, and each stripe is followed by a configurable number of recovery chunks.
+// Each byte position across a stripe is treated as the evaluations of a polynomial over GF(2^8) at x = 0, 1, ...,
+// with the data chunks first, so that any k of the k + m chunks in a stripe are enough to rebuild the rest.
+//
+// A chunk can also come out of BCH decoding wrong without an error, if it had more errors than the ECC can correct and
+// happened to land near another codeword. The chunks of a stripe beyond the first k that survived are used to check
+// that they all lie on the same polynomials, and if they don't, the chunk that disagrees is found and rebuilt as if it
+// were lost (which takes two spare chunks: one to notice, and one more to tell which chunk is wrong). A stripe that
+// disagrees without enough spare chunks to say which chunk is wrong fails, rather than rebuilding garbage.
+
+const (
+	// recoveryStripeSize is the maximum number of data chunks in a single recovery stripe.
+	recoveryStripeSize int = 32
+	// maxRecoveryChunks is the maximum number of recovery chunks per stripe, since every chunk in a stripe needs its
+	// own point in GF(2^8).
+	maxRecoveryChunks  int = 256 - recoveryStripeSize
+)
+
+// Error types
+
+// UnrecoverableChunksError is thrown when more chunks of a stripe were lost than there are recovery chunks for it.
+type UnrecoverableChunksError struct {
+	// Stripe is the index of the stripe that could not be recovered.
+	Stripe   int
+	// Lost is the number of chunks lost in the stripe.
+	Lost     int
+	// Recovery is the number of recovery chunks in the stripe.
+	Recovery int
+}
+
+// Error returns a string that explains the UnrecoverableChunksError.
+func (e *UnrecoverableChunksError) Error() string {
+	return fmt.Sprintf("Stripe %d lost %d chunk(s), but only has %d recovery chunk(s), so it cannot be recovered.",
+		e.Stripe, e.Lost, e.Recovery)
+}
+
+// InconsistentStripeError is thrown when the chunks of a stripe don't agree with each other, which means that at least
+// one of them was decoded wrong, but there aren't enough spare chunks left to tell which.
+type InconsistentStripeError struct {
+	// Stripe is the index of the stripe that could not be recovered.
+	Stripe int
+}
+
+// Error returns a string that explains the InconsistentStripeError.
+func (e *InconsistentStripeError) Error() string {
+	return fmt.Sprintf("The chunks of stripe %d don't agree with each other, and there aren't enough recovery chunks " +
+		"to tell which of them is wrong.", e.Stripe)
+}
+
+// Helper functions
+
+// chunkSizes returns the size in bytes of every chunk that is hidden for a file of fileSize bytes, in order.
+// When recoveryChunks is above 0, each stripe of data chunks is followed by its recovery chunks.
+func chunkSizes(fileSize int64, recoveryChunks uint8) []int {
+	sizes := chunkDataBytes(fileSize, recoveryChunks)
+	for i := range sizes {
+		if sizes[i] <= 0 {
+			sizes[i] = int(encodeChunkSize)
+		}
+	}
+	return sizes
+}
+
+// chunkDataBytes returns the number of bytes of the file held by every chunk returned by chunkSizes, which is 0 for
+// the recovery chunks.
+func chunkDataBytes(fileSize int64, recoveryChunks uint8) []int {
+	var sizes []int
+	stripeLength := 0
+	for pos := int64(0); pos < fileSize; pos += int64(encodeChunkSize) {
+		if fileSize - pos < int64(encodeChunkSize) {
+			sizes = append(sizes, int(fileSize - pos))
+		} else {
+			sizes = append(sizes, int(encodeChunkSize))
+		}
+		stripeLength++
+		if recoveryChunks > 0 && (stripeLength == recoveryStripeSize || pos + int64(encodeChunkSize) >= fileSize) {
+			for i := uint8(0); i < recoveryChunks; i++ {
+				sizes = append(sizes, 0)
+			}
+			stripeLength = 0
+		}
+	}
+	return sizes
+}
+
+// addRecoveryChunks returns the data chunks with recoveryChunks recovery chunks following each stripe of them.
+// All chunks but the last must be full (encodeChunkSize bytes).
+func addRecoveryChunks(chunks [][]byte, recoveryChunks uint8) [][]byte {
+	if recoveryChunks <= 0 {
+		return chunks
+	}
+
+	var ret [][]byte
+	for start := 0; start < len(chunks); start += recoveryStripeSize {
+		stripe := chunks[start:util.Min(start + recoveryStripeSize, len(chunks))]
+		ret = append(ret, stripe...)
+
+		xs := make([]byte, len(stripe))
+		for i := range xs {
+			xs[i] = byte(i)
+		}
+		for i := 0; i < int(recoveryChunks); i++ {
+			ret = append(ret, interpolateChunk(xs, stripe, byte(len(stripe) + i)))
+		}
+	}
+
+	return ret
+}
+
+// recoverChunks rebuilds the data chunks of a file of fileSize bytes from all of its hidden chunks (in the order given
+// by chunkSizes), where chunks that were lost are nil. Only the data chunks are returned.
+func recoverChunks(chunks [][]byte, fileSize int64, recoveryChunks uint8) ([][]byte, error) {
+	if recoveryChunks <= 0 {
+		return chunks, nil
+	}
+
+	var data [][]byte
+	pos, stripeIndex := 0, 0
+	for dataLeft := (fileSize + int64(encodeChunkSize) - 1) / int64(encodeChunkSize); dataLeft > 0; stripeIndex++ {
+		stripeData := util.Min(recoveryStripeSize, int(dataLeft))
+		stripe := append([][]byte(nil), chunks[pos:pos + stripeData + int(recoveryChunks)]...)
+
+		var survivors []byte
+		for i, chunk := range stripe {
+			if chunk != nil {
+				survivors = append(survivors, byte(i))
+			}
+		}
+		if len(survivors) < stripeData {
+			return nil, &UnrecoverableChunksError{Stripe: stripeIndex, Lost: len(stripe) - len(survivors),
+				Recovery: int(recoveryChunks)}
+		}
+
+		// A chunk that disagrees with the rest is treated as lost, if it can be told apart from them
+		if !stripeConsistent(stripe, survivors, stripeData) {
+			wrong := -1
+			if len(survivors) >= stripeData + 2 {
+				for i := range survivors {
+					others := append(append([]byte(nil), survivors[:i]...), survivors[i + 1:]...)
+					if stripeConsistent(stripe, others, stripeData) {
+						wrong = i
+						break
+					}
+				}
+			}
+			if wrong < 0 {
+				return nil, &InconsistentStripeError{Stripe: stripeIndex}
+			}
+			stripe[survivors[wrong]] = nil
+			survivors = append(survivors[:wrong], survivors[wrong + 1:]...)
+		}
+
+		// The first stripeData chunks that survived define the polynomials for the whole stripe
+		xs := survivors[:stripeData]
+		ys := make([][]byte, len(xs))
+		for i, x := range xs {
+			ys[i] = stripe[x]
+		}
+
+		for i := 0; i < stripeData; i++ {
+			chunk := stripe[i]
+			if chunk == nil {
+				chunk = interpolateChunk(xs, ys, byte(i))
+				// The last chunk of the file may be shorter than the rest
+				if i == stripeData - 1 && dataLeft == int64(stripeData) && fileSize % int64(encodeChunkSize) != 0 {
+					chunk = chunk[:fileSize % int64(encodeChunkSize)]
+				}
+			}
+			data = append(data, chunk)
+		}
+
+		pos += stripeData + int(recoveryChunks)
+		dataLeft -= int64(stripeData)
+	}
+
+	return data, nil
+}
+
+// stripeConsistent returns whether the chunks of stripe at xs all lie on the polynomials defined by the first k of
+// them.
+func stripeConsistent(stripe [][]byte, xs []byte, k int) bool {
+	ys := make([][]byte, k)
+	for i, x := range xs[:k] {
+		ys[i] = stripe[x]
+	}
+	for _, x := range xs[k:] {
+		want := interpolateChunk(xs[:k], ys, x)
+		for j, v := range want {
+			// Chunks shorter than encodeChunkSize are zero-padded
+			got := byte(0)
+			if j < len(stripe[x]) {
+				got = stripe[x][j]
+			}
+			if got != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// interpolateChunk evaluates the polynomials defined by the chunks at points xs at x, for every byte position.
+// Chunks shorter than encodeChunkSize are treated as if they were zero-padded.
+func interpolateChunk(xs []byte, chunks [][]byte, x byte) []byte {
+	weights := gf256.LagrangeWeights(xs, x)
+	ret := make([]byte, encodeChunkSize)
+	for i, chunk := range chunks {
+		for j, v := range chunk {
+			ret[j] ^= gf256.Mul(weights[i], v)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,150 @@
+package steg
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// Tests
+
+func TestChunkSizes(t *testing.T) {
+	full := int(encodeChunkSize)
+	tests := []struct {
+		name           string
+		fileSize       int64
+		recoveryChunks uint8
+		want           []int
+	}{
+		{"empty", 0, 0, nil},
+		{"one short chunk", 5, 0, []int{5}},
+		{"one full chunk", int64(full), 0, []int{full}},
+		{"short last chunk", int64(full) + 1, 0, []int{full, 1}},
+		{"short last chunk with recovery", int64(full) + 1, 2, []int{full, 1, full, full}},
+		{"full chunks with recovery", int64(2 * full), 1, []int{full, full, full}},
+		{"one short chunk with recovery", 3, 1, []int{3, full}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := chunkSizes(test.fileSize, test.recoveryChunks); !equalInts(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	// Every full stripe is followed by its recovery chunks, and so is the short one at the end
+	sizes := chunkSizes(int64((recoveryStripeSize + 3) * full - 7), 2)
+	dataBytes := chunkDataBytes(int64((recoveryStripeSize + 3) * full - 7), 2)
+	if len(sizes) != recoveryStripeSize + 2 + 3 + 2 || len(dataBytes) != len(sizes) {
+		t.Fatalf("got %d chunks, want %d", len(sizes), recoveryStripeSize + 2 + 3 + 2)
+	}
+	for i, n := range dataBytes {
+		recovery := i >= recoveryStripeSize && i < recoveryStripeSize + 2 || i >= len(sizes) - 2
+		switch {
+		case recovery && (n != 0 || sizes[i] != full):
+			t.Errorf("Chunk %d should be a recovery chunk, but holds %d B of the file (%d B in all).", i, n, sizes[i])
+		case !recovery && n != sizes[i]:
+			t.Errorf("Chunk %d holds %d B of the file, but is %d B long.", i, n, sizes[i])
+		}
+	}
+	if n := dataBytes[len(sizes) - 3]; n != full - 7 {
+		t.Errorf("The last data chunk holds %d B, want %d", n, full - 7)
+	}
+}
+
+func TestRecoverChunks(t *testing.T) {
+	full := int(encodeChunkSize)
+	tests := []struct {
+		name           string
+		fileSize       int64
+		recoveryChunks uint8
+		lose           []int
+		wrong          []int
+		err            bool
+	}{
+		{"nothing lost", int64(10 * full), 2, nil, nil, false},
+		{"lost data chunks", int64(10 * full), 2, []int{0, 7}, nil, false},
+		{"lost the short last chunk", int64(10 * full - 5), 2, []int{9}, nil, false},
+		{"lost recovery chunks", int64(10 * full), 2, []int{10, 11}, nil, false},
+		{"lost a data and a recovery chunk", int64(10 * full), 2, []int{3, 11}, nil, false},
+		{"lost too many", int64(10 * full), 2, []int{1, 2, 3}, nil, true},
+		{"lost too many recovery chunks and data", int64(10 * full), 1, []int{4, 10}, nil, true},
+		{"lost in both stripes", int64((recoveryStripeSize + 4) * full - 1), 1, []int{5, recoveryStripeSize + 3}, nil,
+			false},
+		{"lost too many in the second stripe", int64((recoveryStripeSize + 4) * full - 1), 1,
+			[]int{recoveryStripeSize + 1, recoveryStripeSize + 2}, nil, true},
+		{"a wrong data chunk", int64(10 * full), 2, nil, []int{4}, false},
+		{"a wrong short last chunk", int64(10 * full - 5), 2, nil, []int{9}, false},
+		{"a wrong recovery chunk", int64(10 * full), 2, nil, []int{11}, false},
+		{"a wrong chunk and a lost one", int64(10 * full), 3, []int{2}, []int{6}, false},
+		{"a wrong chunk without a spare to find it", int64(10 * full), 1, nil, []int{4}, true},
+		{"a wrong chunk and a lost one without a spare", int64(10 * full), 2, []int{2}, []int{6}, true},
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := make([]byte, test.fileSize)
+			r.Read(data)
+			var chunks [][]byte
+			for pos := 0; pos < len(data); pos += full {
+				end := pos + full
+				if end > len(data) {
+					end = len(data)
+				}
+				chunks = append(chunks, data[pos:end])
+			}
+
+			hidden := addRecoveryChunks(chunks, test.recoveryChunks)
+			sizes := chunkSizes(test.fileSize, test.recoveryChunks)
+			if len(hidden) != len(sizes) {
+				t.Fatalf("got %d chunks, but chunkSizes gives %d", len(hidden), len(sizes))
+			}
+			for i, chunk := range hidden {
+				if len(chunk) != sizes[i] {
+					t.Fatalf("Chunk %d is %d B, but chunkSizes gives %d", i, len(chunk), sizes[i])
+				}
+			}
+
+			// Lost chunks are nil, and wrong ones are ones that BCH decoding got wrong without noticing
+			dug := make([][]byte, len(hidden))
+			for i := range hidden {
+				dug[i] = append([]byte(nil), hidden[i]...)
+			}
+			for _, i := range test.lose {
+				dug[i] = nil
+			}
+			for _, i := range test.wrong {
+				dug[i][r.Intn(len(dug[i]))] ^= byte(1 + r.Intn(255))
+			}
+
+			got, err := recoverChunks(dug, test.fileSize, test.recoveryChunks)
+			if test.err {
+				if err == nil {
+					t.Fatal("The chunks were recovered when they shouldn't have been.")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if joined := bytes.Join(got, nil); !bytes.Equal(joined, data) {
+				t.Fatal("The recovered file is wrong.")
+			}
+		})
+	}
+}
+
+// Helper functions
+
+// equalInts returns whether a and b hold the same values.
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,627 @@
+package steg
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"math"
+
+	"github.com/zedseven/bch"
+	"github.com/zedseven/binmani"
+	"github.com/zedseven/steg/internal/algos"
+	"github.com/zedseven/steg/internal/util"
+)
+
+// Robust mode (AlgoRobust) hides data in the frequency domain of the image, instead of in the least-significant bits
+// of the channels. The image is split into cells of 8x8px (lined up with the blocks JPEG uses), and each cell stores a
+// single bit in the relationship between a pair of mirrored mid-frequency DCT coefficients of its luminance: the bit
+// is 1 if the first is larger than the second by at least a margin, and 0 if it is smaller by at least the margin.
+// This is a form of quantization index modulation on the difference of the coefficients, with just two cells in the
+// lattice. Unlike modulating a single coefficient, it is not thrown off by the overall gain of the coefficients
+// changing, which resampling does. The margin is well above the quantization error of JPEG at a quality of 75.
+// Every bit is repeated across several cells, and the chunks are heavily ECC-protected and interleaved. A bit is read
+// back from the sum of the coefficient differences of its cells, each clamped to the margin. That is the same as a
+// majority vote while the cells are intact, but after resampling and recompression most cells are left with only a
+// sliver of their difference, and one cell that was knocked just past zero shouldn't outvote two that kept the bit.
+//
+// Since the cells are defined relative to the original image dimensions (which are stored in the header), the data
+// also survives mild resizing. When the dimensions have changed, Dig searches for the original ones. Resizing before
+// recompressing is much harder on the data than the other way around, since the cells no longer line up with the
+// blocks of the JPEG, and it is worst for large, flat images that only have a few cells written to. Re-encoding at a
+// quality of 75 has been tested together with resizing by 0.8 to 1.1 in either order; beyond that, expect the header
+// to be lost.
+//
+// Partially-transparent pixels are treated as if they were composited over black, which is what happens to them when
+// they are converted to JPEG.
+
+const (
+	// robustCellSize is the width and height of a cell at the original image size.
+	robustCellSize     int     = 8
+	// robustCoefU and robustCoefV are the horizontal and vertical frequency of the first DCT coefficient of the pair.
+	// The second one is the mirror of it, with the frequencies swapped.
+	robustCoefU        int     = 2
+	robustCoefV        int     = 1
+	// robustMargin is the minimum difference between the coefficients. Larger margins survive lower JPEG qualities,
+	// but are more visible.
+	robustMargin       float64 = 20
+	// robustMaxChange is the most a cell's coefficient difference is changed by, to limit the visible distortion in
+	// highly-textured cells. Cells that would need more are left to the ECC.
+	robustMaxChange    float64 = 120
+	// robustRepetition is the number of cells each bit is written to.
+	robustRepetition   int     = 3
+	// robustHeaderSize is the size of the robust-mode header in bytes.
+	robustHeaderSize   int     = 21
+	// robustHeaderErrors is the number of correctable errors used for the header.
+	robustHeaderErrors uint8   = 12
+	// robustMinErrors is the minimum number of correctable errors used per file chunk.
+	robustMinErrors    uint8   = 16
+	// robustMaxAttempts is the number of times a cell is re-modulated to counter rounding and clamping.
+	robustMaxAttempts  int     = 4
+	// robustMinScale and robustMaxScale bound the resizing that Dig searches for.
+	robustMinScale     float64 = 0.75
+	robustMaxScale     float64 = 1.33
+)
+
+// robustBasis is the difference of the DCT basis functions of the coefficient pair, indexed [y][x]. Since the DCT is
+// orthonormal, the dot product of a cell with it is the difference of the coefficients, and adding d times it to a
+// cell changes the difference by 2d.
+var robustBasis [robustCellSize][robustCellSize]float64
+
+func init() {
+	alpha := func(k int) float64 {
+		if k == 0 {
+			return math.Sqrt(1 / float64(robustCellSize))
+		}
+		return math.Sqrt(2 / float64(robustCellSize))
+	}
+	basis := func(u, v, x, y int) float64 {
+		return alpha(u) * alpha(v) *
+			math.Cos(float64(2 * x + 1) * float64(u) * math.Pi / float64(2 * robustCellSize)) *
+			math.Cos(float64(2 * y + 1) * float64(v) * math.Pi / float64(2 * robustCellSize))
+	}
+	for y := 0; y < robustCellSize; y++ {
+		for x := 0; x < robustCellSize; x++ {
+			robustBasis[y][x] = basis(robustCoefU, robustCoefV, x, y) - basis(robustCoefV, robustCoefU, x, y)
+		}
+	}
+}
+
+// Types
+
+// robustCarrier provides bit-level access to the cells of an image for robust mode.
+type robustCarrier struct {
+	pixels         *pixelBuffer
+	info           imgInfo
+	// colourChannels are the channels that make up the colour of a pixel, and lumaWeights their share of the luminance.
+	colourChannels []int
+	lumaWeights    []float64
+	// scale converts channel values to the 0-255 range.
+	scale          float64
+	// alphaChannel is the alpha channel to composite the colour over black with, or -1 if there is none to apply.
+	alphaChannel   int
+	luma           []float64
+	// origW and origH are the dimensions the cells are defined relative to.
+	origW, origH   int
+	cellsX, cellsY int
+}
+
+// robustStream reads and writes bits to the cells of a robustCarrier, in the order handed out by an addressor.
+type robustStream struct {
+	carrier *robustCarrier
+	pos     func() (int64, error)
+	// tracker is checked before every bit, so that the stream stops once its context is cancelled.
+	tracker *progressTracker
+}
+
+// Primary methods
+
+func hideRobust(tracker *progressTracker, opts *Options, pixels *pixelBuffer, info imgInfo, pHash int64, data []byte, part partInfo, logger Logger) error {
+	carrier, err := newRobustCarrier(pixels, info)
+	if err != nil {
+		return err
+	}
+	carrier.setOriginalSize(int(info.W), int(info.H))
+	logger.Log(OutputInfo, fmt.Sprintf("The image has %dx%d cells to hide data in.", carrier.cellsX, carrier.cellsY))
+
+	fsize := int64(len(data))
+	tracker.start(fsize, 0)
+
+	logger.Log(OutputSteps, "Setting up data ECC...")
+	headerECC, dataECC, err := robustECCConfigs(opts.maxCorrectableErrors)
+	if err != nil {
+		return err
+	}
+	logger.Log(OutputInfo, fmt.Sprintf("Using a %v for the header and a %v for the data.", headerECC, dataECC))
+
+	headerBits, fileBits := robustBitsToWrite(headerECC, dataECC, fsize, opts.recoveryChunks)
+	logger.Log(OutputSteps, "Counted the actual cells to write, including ECC and repetition.", "cells", headerBits + fileBits)
+	if headerBits + fileBits > carrier.cellCount() {
+		return &InsufficientHidingSpotsError{AdditionalInfo:fmt.Sprintf("Since the number of cells to write is %d " +
+			"and the image only has %d, there is no way the input file will fit.", headerBits + fileBits, carrier.cellCount())}
+	}
+
+	f, err := algos.AlgoAddressor(algos.AlgoRobust, pHash, carrier.cellCount(), 1)
+	if err != nil {
+		return err
+	}
+	stream := &robustStream{carrier, f, tracker}
+
+
+	logger.Log(OutputSteps, "Writing steg header...")
+
+	header := make([]byte, robustHeaderSize)
+	header[0] = VersionMax
+	header[1] = VersionMid
+	header[2] = VersionMin
+	header[3] = byte(0xff & (info.W >> 8))
+	header[4] = byte(0xff & info.W)
+	header[5] = byte(0xff & (info.H >> 8))
+	header[6] = byte(0xff & info.H)
+	header[7] = byte(0xff & (fsize >> 24))
+	header[8] = byte(0xff & (fsize >> 16))
+	header[9] = byte(0xff & (fsize >> 8))
+	header[10] = byte(0xff & fsize)
+	copy(header[11:11 + layoutSize], opts.marshalLayout())
+	copy(header[14:14 + partInfoSize], part.marshal())
+
+	codeword, err := encodeChunk(headerECC, header, logger)
+	if err != nil {
+		return err
+	}
+	if err = stream.writeBits(codeword); err != nil {
+		return err
+	}
+
+
+	logger.Log(OutputSteps, "Writing file data...")
+
+	var chunks [][]byte
+	for pos := 0; pos < len(data); pos += int(encodeChunkSize) {
+		chunks = append(chunks, data[pos:util.Min(pos + int(encodeChunkSize), len(data))])
+	}
+	chunks = addRecoveryChunks(chunks, opts.recoveryChunks)
+
+	codewords, err := encodeChunks(tracker, dataECC, chunks, chunkDataBytes(fsize, opts.recoveryChunks), logger)
+	if err != nil {
+		return err
+	}
+
+	return stream.writeBits(interleave(codewords))
+}
+
+func digRobust(tracker *progressTracker, imagePath string, opts *Options, pixels *pixelBuffer, info imgInfo, pHash int64, logger Logger) (*dugPart, error) {
+	carrier, err := newRobustCarrier(pixels, info)
+	if err != nil {
+		return nil, err
+	}
+
+	headerECC, _, err := robustECCConfigs(opts.maxCorrectableErrors)
+	if err != nil {
+		return nil, err
+	}
+
+
+	logger.Log(OutputSteps, "Reading steg header...")
+
+	stream, header, eccErrors, err := findRobustHeader(tracker, carrier, pHash, headerECC, logger)
+	if err != nil {
+		return nil, err
+	}
+	if carrier.origW != int(info.W) || carrier.origH != int(info.H) {
+		logger.Log(OutputInfo, fmt.Sprintf("The image was resized from %dx%dpx since it was encoded.",
+			carrier.origW, carrier.origH))
+	}
+
+	encodeVersionMax := header[0]
+	encodeVersionMid := header[1]
+	encodeVersionMin := header[2]
+	fileSize := int64(header[7]) << 24 | int64(header[8]) << 16 | int64(header[9]) << 8 | int64(header[10])
+
+	logger.Log(OutputInfo, fmt.Sprintf("This image was encoded with steg v%d.%d.%d.",
+		encodeVersionMax, encodeVersionMid, encodeVersionMin))
+
+	if encodeVersionMax != VersionMax || encodeVersionMid != VersionMid || encodeVersionMin != VersionMin {
+		logger.Log(OutputSteps,
+			"This image was encoded with a different version of Steg. The program will continue, but in the case " +
+			"of strange errors or issues, try using the same version as the image was originally encoded with.")
+	}
+
+	logger.Log(OutputInfo, fmt.Sprintf("Output file size: %d B", fileSize))
+	tracker.start(fileSize, eccErrors)
+
+	if opts, err = opts.withLayout(header[11:11 + layoutSize]); err != nil {
+		return nil, err
+	}
+	part, err := parsePartInfo(header[14:14 + partInfoSize])
+	if err != nil {
+		return nil, err
+	}
+	if part.count > 1 {
+		logger.Log(OutputInfo, fmt.Sprintf("This image holds part %d of %d of the file.", part.index + 1, part.count),
+			"set", part.setID)
+	}
+
+	logger.Log(OutputSteps, "Setting up data ECC...")
+	_, dataECC, err := robustECCConfigs(opts.maxCorrectableErrors)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log(OutputInfo, fmt.Sprintf("Using a %v for the header and a %v for the data.", headerECC, dataECC))
+
+
+	logger.Log(OutputSteps, "Reading file data...")
+
+	sizes := chunkSizes(fileSize, opts.recoveryChunks)
+	lengths := make([]int, len(sizes))
+	totalLength := 0
+	for i, n := range sizes {
+		lengths[i] = chunkCodeLength(dataECC, n)
+		totalLength += lengths[i]
+	}
+	bits, err := stream.readBits(totalLength)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, chunkErrors, errs, err := decodeChunks(tracker, dataECC, deinterleave(bits, lengths), sizes,
+		chunkDataBytes(fileSize, opts.recoveryChunks), logger)
+	if err != nil {
+		return nil, err
+	}
+	lostChunks := 0
+	for i, err := range errs {
+		if err != nil {
+			switch err.(type) {
+			case bch.DataTooCorruptError:
+				if opts.recoveryChunks > 0 {
+					lostChunks++
+					continue
+				}
+				return nil, err
+			default:
+				return nil, err
+			}
+		}
+		eccErrors += chunkErrors[i]
+	}
+	if opts.recoveryChunks > 0 {
+		logger.Log(OutputInfo, fmt.Sprintf("%d chunk(s) were lost and need to be recovered.", lostChunks))
+		if chunks, err = recoverChunks(chunks, fileSize, opts.recoveryChunks); err != nil {
+			return nil, err
+		}
+	}
+	logger.Log(OutputInfo, fmt.Sprintf("There were %d error(s) in the image.", eccErrors))
+
+	return &dugPart{imagePath, bytes.Join(chunks, nil), part, opts, eccErrors}, nil
+}
+
+// Helper functions
+
+// robustECCConfigs creates the ECC configurations for the robust-mode header and file chunks.
+func robustECCConfigs(maxCorrectableErrors uint8) (headerECC, dataECC *bch.EncodingConfig, err error) {
+	if headerECC, err = createECCConfig(robustHeaderSize, robustHeaderErrors); err != nil {
+		return nil, nil, err
+	}
+	if dataECC, err = createECCConfig(int(encodeChunkSize), uint8(util.Max(int(maxCorrectableErrors), int(robustMinErrors)))); err != nil {
+		return nil, nil, err
+	}
+	return headerECC, dataECC, nil
+}
+
+// robustBitsToWrite returns the number of cells taken up by the header, and by a file of fileSize bytes.
+func robustBitsToWrite(headerECC, dataECC *bch.EncodingConfig, fileSize int64, recoveryChunks uint8) (headerBits, fileBits int64) {
+	headerBits = int64(chunkCodeLength(headerECC, robustHeaderSize) * robustRepetition)
+	for _, n := range chunkSizes(fileSize, recoveryChunks) {
+		fileBits += int64(chunkCodeLength(dataECC, n) * robustRepetition)
+	}
+	return headerBits, fileBits
+}
+
+// findRobustHeader reads the robust-mode header, searching for the original image dimensions if the image has been
+// resized. It returns the stream positioned after the header. The search stops if the context of tracker is cancelled.
+func findRobustHeader(tracker *progressTracker, carrier *robustCarrier, pHash int64, headerECC *bch.EncodingConfig, logger Logger) (*robustStream, []byte, int, error) {
+	w, h := int(carrier.info.W), int(carrier.info.H)
+
+	candidates := [][2]int{{w, h}}
+	for origW := int(math.Ceil(float64(w) / robustMaxScale)); origW <= int(float64(w) / robustMinScale); origW++ {
+		origH := int(math.Round(float64(origW) * float64(h) / float64(w)))
+		for _, candidateH := range []int{origH, origH - 1, origH + 1} {
+			if origW != w || candidateH != h {
+				candidates = append(candidates, [2]int{origW, candidateH})
+			}
+		}
+	}
+
+	codeLength := chunkCodeLength(headerECC, robustHeaderSize)
+	for _, candidate := range candidates {
+		if err := tracker.err(); err != nil {
+			return nil, nil, -1, err
+		}
+		if candidate[0] < robustCellSize || candidate[1] < robustCellSize {
+			continue
+		}
+		carrier.setOriginalSize(candidate[0], candidate[1])
+		if int64(codeLength * robustRepetition) > carrier.cellCount() {
+			continue
+		}
+
+		f, err := algos.AlgoAddressor(algos.AlgoRobust, pHash, carrier.cellCount(), 1)
+		if err != nil {
+			return nil, nil, -1, err
+		}
+		stream := &robustStream{carrier, f, tracker}
+
+		// Reading the whole header and decoding it is expensive, so resized candidates are first checked against the
+		// dimensions they expect to find. Random data has around 16 mismatches in them, while the right candidate
+		// should have close to none.
+		if candidate[0] != w && candidate[1] != h {
+			expected := binmani.BytesToBits([]byte{byte(candidate[0] >> 8), byte(candidate[0]), byte(candidate[1] >> 8), byte(candidate[1])})
+			if err = stream.skipBits(checksumBits(headerECC) + 3 * int(bitsPerByte)); err != nil {
+				return nil, nil, -1, err
+			}
+			dims, err := stream.readBits(len(*expected))
+			if err != nil {
+				return nil, nil, -1, err
+			}
+			mismatches := 0
+			for i, bit := range *expected {
+				if dims[i] != bit {
+					mismatches++
+				}
+			}
+			if mismatches > int(robustHeaderErrors) / 2 {
+				continue
+			}
+
+			if f, err = algos.AlgoAddressor(algos.AlgoRobust, pHash, carrier.cellCount(), 1); err != nil {
+				return nil, nil, -1, err
+			}
+			stream = &robustStream{carrier, f, tracker}
+		}
+
+		bits, err := stream.readBits(codeLength)
+		if err != nil {
+			return nil, nil, -1, err
+		}
+
+		header, errors, err := decodeChunk(headerECC, bits, robustHeaderSize, nopLogger{})
+		if err != nil {
+			continue
+		}
+		if int(header[3]) << 8 | int(header[4]) != candidate[0] || int(header[5]) << 8 | int(header[6]) != candidate[1] {
+			continue
+		}
+
+		logger.Log(OutputDebug, "Found the header with the original dimensions.", "width", candidate[0], "height", candidate[1])
+		return stream, header, errors, nil
+	}
+
+	return nil, nil, -1, &BadHeaderError{}
+}
+
+// newRobustCarrier sets up a robustCarrier for the pixels of an image. Only RGB and greyscale images are supported.
+func newRobustCarrier(pixels *pixelBuffer, info imgInfo) (*robustCarrier, error) {
+	carrier := &robustCarrier{
+		pixels:       pixels,
+		info:         info,
+		scale:        float64(uint16(1 << info.Format.BitsPerChannel - 1)) / 255,
+		alphaChannel: -1,
+	}
+
+	switch info.Format.Model {
+	case color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model:
+		carrier.colourChannels = []int{0, 1, 2}
+		carrier.lumaWeights = []float64{0.299, 0.587, 0.114}
+		// The premultiplied models are already composited over black
+		if info.Format.Model == color.NRGBAModel || info.Format.Model == color.NRGBA64Model {
+			carrier.alphaChannel = int(info.Format.alphaChannel())
+		}
+	case color.GrayModel, color.Gray16Model:
+		carrier.colourChannels = []int{0}
+		carrier.lumaWeights = []float64{1}
+	default:
+		return nil, &InvalidFormatError{fmt.Sprintf("The %v colour model is not supported by the robust algorithm.",
+			colourModelToStr(info.Format.Model))}
+	}
+
+	carrier.luma = make([]float64, pixels.count())
+	for i := range carrier.luma {
+		carrier.updateLuma(int64(i))
+	}
+
+	return carrier, nil
+}
+
+// setOriginalSize sets the image dimensions that the cells are defined relative to.
+func (c *robustCarrier) setOriginalSize(w, h int) {
+	c.origW, c.origH = w, h
+	c.cellsX, c.cellsY = w / robustCellSize, h / robustCellSize
+}
+
+// cellCount returns the number of cells in the image.
+func (c *robustCarrier) cellCount() int64 {
+	return int64(c.cellsX) * int64(c.cellsY)
+}
+
+// area returns the pixel rectangle [x0, x1) x [y0, y1) of the current image that covers the sample at (sx, sy) of a
+// cell. At the original size, this is a single pixel.
+func (c *robustCarrier) area(cell int64, sx, sy int) (x0, y0, x1, y1 int) {
+	w, h := int(c.info.W), int(c.info.H)
+	ox := int(cell % int64(c.cellsX)) * robustCellSize + sx
+	oy := int(cell / int64(c.cellsX)) * robustCellSize + sy
+	x0, x1 = ox * w / c.origW, (ox + 1) * w / c.origW
+	y0, y1 = oy * h / c.origH, (oy + 1) * h / c.origH
+	if x1 <= x0 {
+		x1 = x0 + 1
+	}
+	if y1 <= y0 {
+		y1 = y0 + 1
+	}
+	return util.Min(x0, w - 1), util.Min(y0, h - 1), util.Min(x1, w), util.Min(y1, h)
+}
+
+// difference computes the difference between the pair of DCT coefficients of a cell's luminance.
+func (c *robustCarrier) difference(cell int64) float64 {
+	diff := 0.0
+	for sy := 0; sy < robustCellSize; sy++ {
+		for sx := 0; sx < robustCellSize; sx++ {
+			diff += robustBasis[sy][sx] * c.sample(cell, sx, sy)
+		}
+	}
+	return diff
+}
+
+// sample returns the luminance of the sample at (sx, sy) of a cell. When the image has been enlarged, it is the
+// average of the pixels that make up the sample, and when it has been shrunk, it is interpolated from the pixels
+// around the centre of the sample.
+func (c *robustCarrier) sample(cell int64, sx, sy int) float64 {
+	w, h := int(c.info.W), int(c.info.H)
+	if w >= c.origW && h >= c.origH {
+		x0, y0, x1, y1 := c.area(cell, sx, sy)
+		sum := 0.0
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				sum += c.luma[y * w + x]
+			}
+		}
+		return sum / float64((x1 - x0) * (y1 - y0))
+	}
+
+	ox := int(cell % int64(c.cellsX)) * robustCellSize + sx
+	oy := int(cell / int64(c.cellsX)) * robustCellSize + sy
+	fx := math.Max(0, (float64(ox) + 0.5) * float64(w) / float64(c.origW) - 0.5)
+	fy := math.Max(0, (float64(oy) + 0.5) * float64(h) / float64(c.origH) - 0.5)
+	x0, y0 := util.Min(int(fx), w - 1), util.Min(int(fy), h - 1)
+	x1, y1 := util.Min(x0 + 1, w - 1), util.Min(y0 + 1, h - 1)
+	tx, ty := fx - float64(x0), fy - float64(y0)
+	top := c.luma[y0 * w + x0] * (1 - tx) + c.luma[y0 * w + x1] * tx
+	bottom := c.luma[y1 * w + x0] * (1 - tx) + c.luma[y1 * w + x1] * tx
+	return top * (1 - ty) + bottom * ty
+}
+
+// writeBit stores a bit in a cell, by pushing its coefficient difference past the margin on the side of the bit.
+// Only ever used at the original image size, where every sample of the cell is a single pixel.
+func (c *robustCarrier) writeBit(cell int64, bit uint8) {
+	target := robustMargin
+	if bit == 0 {
+		target = -robustMargin
+	}
+
+	totalChange := 0.0
+	for attempt := 0; attempt < robustMaxAttempts; attempt++ {
+		diff := c.difference(cell)
+		if (bit == 1 && diff >= target) || (bit == 0 && diff <= target) {
+			return
+		}
+		// Aim slightly past the margin, so that rounding doesn't leave it just short
+		change := target - diff + (target - diff) / math.Abs(target - diff)
+		change = math.Max(-robustMaxChange - totalChange, math.Min(robustMaxChange - totalChange, change))
+		if math.Abs(change) < 0.5 {
+			return
+		}
+		totalChange += change
+		c.shift(cell, change)
+	}
+}
+
+// scrub moves the coefficient difference of a cell to target, by at most robustMaxChange in total. Only ever used at
+// the original image size.
+func (c *robustCarrier) scrub(cell int64, target float64) {
+	totalChange := 0.0
+	for attempt := 0; attempt < robustMaxAttempts; attempt++ {
+		change := target - c.difference(cell)
+		change = math.Max(-robustMaxChange - totalChange, math.Min(robustMaxChange - totalChange, change))
+		if math.Abs(change) < 0.5 {
+			return
+		}
+		totalChange += change
+		c.shift(cell, change)
+	}
+}
+
+// shift changes the coefficient difference of a cell by change, by adding the basis to its pixels.
+func (c *robustCarrier) shift(cell int64, change float64) {
+	w := int(c.info.W)
+	for sy := 0; sy < robustCellSize; sy++ {
+		for sx := 0; sx < robustCellSize; sx++ {
+			x0, y0, _, _ := c.area(cell, sx, sy)
+			p := int64(y0 * w + x0)
+			lumaChange := change / 2 * robustBasis[sy][sx]
+			if c.alphaChannel >= 0 {
+				alpha := float64(c.pixels.channel(p, uint8(c.alphaChannel))) / c.scale / 255
+				if alpha <= 0 {
+					continue
+				}
+				lumaChange /= alpha
+			}
+			for _, ch := range c.colourChannels {
+				v := float64(c.pixels.channel(p, uint8(ch))) / c.scale + lumaChange
+				v = math.Max(0, math.Min(255, v))
+				c.pixels.setChannel(p, uint8(ch), uint16(math.Round(v * c.scale)))
+			}
+			c.updateLuma(p)
+		}
+	}
+}
+
+// updateLuma recomputes the luminance of pixel p.
+func (c *robustCarrier) updateLuma(p int64) {
+	luma := 0.0
+	for i, ch := range c.colourChannels {
+		luma += c.lumaWeights[i] * float64(c.pixels.channel(p, uint8(ch))) / c.scale
+	}
+	if c.alphaChannel >= 0 {
+		luma *= float64(c.pixels.channel(p, uint8(c.alphaChannel))) / c.scale / 255
+	}
+	c.luma[p] = luma
+}
+
+// writeBits writes each of bits to robustRepetition cells.
+func (s *robustStream) writeBits(bits []uint8) error {
+	for _, bit := range bits {
+		if err := s.tracker.err(); err != nil {
+			return err
+		}
+		for r := 0; r < robustRepetition; r++ {
+			cell, err := s.pos()
+			if err != nil {
+				return &InsufficientHidingSpotsError{InnerError:err}
+			}
+			s.carrier.writeBit(cell, bit)
+		}
+	}
+	return nil
+}
+
+// readBits reads n bits, from the sum of the coefficient differences of the robustRepetition cells each one was
+// written to, each clamped to the margin.
+func (s *robustStream) readBits(n int) ([]uint8, error) {
+	bits := make([]uint8, n)
+	for i := range bits {
+		if err := s.tracker.err(); err != nil {
+			return nil, err
+		}
+		sum := 0.0
+		for r := 0; r < robustRepetition; r++ {
+			cell, err := s.pos()
+			if err != nil {
+				return nil, &InsufficientHidingSpotsError{InnerError:err}
+			}
+			sum += math.Max(-robustMargin, math.Min(robustMargin, s.carrier.difference(cell)))
+		}
+		if sum > 0 {
+			bits[i] = 1
+		}
+	}
+	return bits, nil
+}
+
+// skipBits moves past n bits without reading them.
+func (s *robustStream) skipBits(n int) error {
+	for i := 0; i < n * robustRepetition; i++ {
+		if _, err := s.pos(); err != nil {
+			return &InsufficientHidingSpotsError{InnerError:err}
+		}
+	}
+	return nil
+}
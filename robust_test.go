@@ -0,0 +1,173 @@
+package steg
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zedseven/steg/internal/algos"
+	"github.com/zedseven/steg/internal/util"
+)
+
+// robustTestQuality is the JPEG quality that robust mode is meant to survive.
+const robustTestQuality = 75
+
+// Tests
+
+// TestRobustReencodeAndResize checks that robust mode survives the image being re-encoded as a JPEG and resized, in
+// either order.
+func TestRobustReencodeAndResize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	imagePath := writeWhiteTestImage(t, dir)
+	filePath, data := writeTestFile(t, dir, 40)
+
+	outPath := filepath.Join(dir, "out.png")
+	opts := NewOptions(WithAlgorithm(algos.AlgoRobust), WithPassphrase("hunter2"))
+	if _, err := Hide(&HideConfig{ImagePath: imagePath, FilePath: filePath, OutPath: outPath, Options: opts},
+		nil); err != nil {
+		t.Fatal(err)
+	}
+	hidden := readPNG(t, outPath)
+
+	tests := []struct {
+		scale       float64
+		resizeFirst bool
+	}{
+		{1, false},
+		{0.8, false},
+		{0.8, true},
+		{0.9, false},
+		{0.9, true},
+		{1.1, false},
+		{1.1, true},
+	}
+	for _, test := range tests {
+		name := fmt.Sprintf("re-encoded, then resized by %v", test.scale)
+		if test.scale == 1 {
+			name = "re-encoded"
+		} else if test.resizeFirst {
+			name = fmt.Sprintf("resized by %v, then re-encoded", test.scale)
+		}
+		t.Run(name, func(t *testing.T) {
+			transformed := hidden
+			if test.resizeFirst {
+				transformed = resizeImage(transformed, test.scale)
+			}
+			transformed = reencodeJPEG(t, transformed, robustTestQuality)
+			if !test.resizeFirst && test.scale != 1 {
+				transformed = resizeImage(transformed, test.scale)
+			}
+
+			transformedPath := filepath.Join(dir, "transformed.png")
+			if err := writePNG(transformed, transformedPath); err != nil {
+				t.Fatal(err)
+			}
+			if got := digTestFile(t, dir, transformedPath, opts); !bytes.Equal(got, data) {
+				t.Fatal("The file couldn't be dug up.")
+			}
+		})
+	}
+}
+
+// Helper functions
+
+// writeWhiteTestImage writes an opaque white image to dir, and returns its path. White images are the hardest for
+// robust mode, since the channels clip as soon as they are brightened, and there is no texture to hide changes in.
+func writeWhiteTestImage(t *testing.T, dir string) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, testImageSize * 4, testImageSize * 4))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	p := filepath.Join(dir, "white.png")
+	if err := writePNG(img, p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// readPNG decodes the PNG image at p.
+func readPNG(t *testing.T, p string) image.Image {
+	t.Helper()
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// reencodeJPEG encodes img as a JPEG of the given quality, and decodes it again.
+func reencodeJPEG(t *testing.T, img image.Image, quality int) image.Image {
+	t.Helper()
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// resizeImage resizes the opaque image img by scale, with a triangle filter that is widened when shrinking, the way
+// image editors do.
+func resizeImage(img image.Image, scale float64) *image.NRGBA {
+	src := image.NewNRGBA(img.Bounds())
+	draw.Draw(src, src.Rect, img, img.Bounds().Min, draw.Src)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	newW, newH := int(math.Round(float64(w) * scale)), int(math.Round(float64(h) * scale))
+
+	// Resize the rows, and then the columns of the result
+	rows := make([]float64, newW * h * 3)
+	for y := 0; y < h; y++ {
+		for c := 0; c < 3; c++ {
+			resample(func(x int) float64 { return float64(src.Pix[src.PixOffset(x, y) + c]) }, w, newW,
+				func(x int, v float64) { rows[(y * newW + x) * 3 + c] = v })
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, newW, newH))
+	for x := 0; x < newW; x++ {
+		for c := 0; c < 3; c++ {
+			resample(func(y int) float64 { return rows[(y * newW + x) * 3 + c] }, h, newH, func(y int, v float64) {
+				dst.Pix[dst.PixOffset(x, y) + c] = uint8(math.Max(0, math.Min(255, math.Round(v))))
+			})
+		}
+	}
+	for i := 3; i < len(dst.Pix); i += 4 {
+		dst.Pix[i] = 0xff
+	}
+	return dst
+}
+
+// resample resamples the n values given by get to newN values, which are handed to set.
+func resample(get func(i int) float64, n, newN int, set func(i int, v float64)) {
+	scale := float64(newN) / float64(n)
+	support := math.Max(1, 1 / scale)
+	for i := 0; i < newN; i++ {
+		centre := (float64(i) + 0.5) / scale - 0.5
+		sum, weights := 0.0, 0.0
+		for j := int(math.Ceil(centre - support)); j <= int(math.Floor(centre + support)); j++ {
+			weight := 1 - math.Abs(float64(j) - centre) / support
+			if weight <= 0 {
+				continue
+			}
+			sum += weight * get(util.Max(0, util.Min(j, n - 1)))
+			weights += weight
+		}
+		set(i, sum / weights)
+	}
+}
@@ -0,0 +1,164 @@
+package steg
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"math"
+	mrand "math/rand"
+)
+
+// Sanitizing an image destroys anything that might be hidden in it, for images from sources that aren't trusted. The
+// low bits of every channel are overwritten, so any data that this tool hid with -bits up to that many is gone,
+// whichever algorithm and key it was hidden with. The low bits of the alpha channel are set to 1 wherever the pixel
+// isn't fully transparent, which destroys data hidden with -alpha without making opaque pixels translucent. Since
+// robust mode hides its data in the DCT coefficients of 8x8px cells rather than in the low bits, the coefficient
+// difference that it uses is then moved to a small random value in every cell that it could have written to as well.
+//
+// What isn't destroyed: data hidden in the most-significant bits with -msb, data hidden with -bits above Bits, and
+// robust-mode data in an image that was resized after it was hidden (since the cells are only scrubbed at the current
+// size). Keeping the alpha channel or the cells, if asked to, leaves whatever is hidden in them as well. The image is
+// always written as a fresh PNG with nothing but its pixels, so any ancillary chunks (text, timestamps, EXIF and so
+// on) are dropped too.
+
+const (
+	// sanitizeCellRange is the largest coefficient difference, either way, that cells are scrubbed to.
+	sanitizeCellRange float64 = robustMargin / 2
+	// sanitizeCellLimit is the largest coefficient difference, either way, of the cells that are scrubbed. Robust mode
+	// only pushes differences just past robustMargin, so the cells further out than this hold the image's own texture,
+	// which only agrees with a hidden bit by chance - and flattening them would blur the image.
+	sanitizeCellLimit float64 = 2 * robustMargin
+)
+
+// Types
+
+// SanitizeConfig stores the configuration options for Sanitize.
+type SanitizeConfig struct {
+	// ImagePath is the path on disk to a supported image.
+	ImagePath string
+	// OutPath is the path on disk to write the sanitized image to, as a PNG.
+	OutPath   string
+	// Bits is the number of least-significant bits of every channel to overwrite (1-16). It's capped at the number of
+	// bits per channel of the image.
+	Bits      uint8
+	// Zero is whether to set the low bits to 0, instead of to random values. Random values are harder to tell apart
+	// from a clean image, but zeroes leave the image smaller.
+	Zero      bool
+	// Noise is the largest amount to randomly shift every channel value by, up or down, before the low bits are
+	// overwritten. It's 0 for no noise.
+	Noise     uint16
+	// KeepAlpha is whether to leave the alpha channel as it is, instead of setting its low bits to 1. It's ignored if
+	// alpha is the only channel of the image.
+	KeepAlpha bool
+	// KeepCells is whether to leave the DCT coefficients of the 8x8px cells as they are, which changes the image less,
+	// but leaves anything hidden with the robust algorithm.
+	KeepCells bool
+}
+
+// Library methods
+
+// Sanitize overwrites the low bits of every channel of the image at config.ImagePath and scrubs its 8x8px cells, and
+// writes the result to config.OutPath. It returns how much the image was changed by it.
+func Sanitize(config *SanitizeConfig, logger Logger) (*QualityReport, error) {
+	logger = loggerOrNop(logger)
+
+	if len(config.ImagePath) <= 0 {
+		return nil, &InvalidFormatError{"ImagePath is empty."}
+	}
+	if len(config.OutPath) <= 0 {
+		return nil, &InvalidFormatError{"OutPath is empty."}
+	}
+	if config.Bits < 1 || config.Bits > 16 {
+		return nil, &InvalidFormatError{fmt.Sprintf("Bits (%d) must be between 1 and 16.", config.Bits)}
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Loading the image from '%v'...", config.ImagePath))
+	pixels, info, err := loadImage(config.ImagePath, logger)
+	if err = checkLoaded(pixels, err); err != nil {
+		logger.Log(OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", config.ImagePath))
+		return nil, err
+	}
+	original := newPixelBuffer(pixels.img, append([]uint8(nil), pixels.pix...), info.Format)
+
+	var seed [8]byte
+	if _, err = rand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	r := mrand.New(mrand.NewSource(int64(binary.BigEndian.Uint64(seed[:]))))
+
+	bits := config.Bits
+	if bits > info.Format.BitsPerChannel {
+		bits = info.Format.BitsPerChannel
+	}
+	max := int64(uint32(1) << info.Format.BitsPerChannel - 1)
+	lowMask := uint16(uint32(1) << bits - 1)
+	alphaChannel := int8(-1)
+	if info.Format.supportsAlpha() {
+		alphaChannel = info.Format.alphaChannel()
+	}
+	// The alpha channel only gets the same treatment as the colour channels if it's the only one
+	onlyAlpha := info.Format.ChannelsPerPix <= 1
+	premultiplied := alphaChannel >= 0 && (info.Format.Model == color.RGBAModel || info.Format.Model == color.RGBA64Model)
+
+	if !config.KeepCells {
+		if carrier, err := newRobustCarrier(pixels, info); err == nil {
+			logger.Log(OutputSteps, "Scrubbing the DCT coefficients of every cell...")
+			carrier.setOriginalSize(int(info.W), int(info.H))
+			for cell := int64(0); cell < carrier.cellCount(); cell++ {
+				if math.Abs(carrier.difference(cell)) <= sanitizeCellLimit {
+					carrier.scrub(cell, (2 * r.Float64() - 1) * sanitizeCellRange)
+				}
+			}
+		}
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Overwriting the low %d bit(s) of every channel...", bits))
+	for p := int64(0); p < pixels.count(); p++ {
+		for c := uint8(0); c < info.Format.ChannelsPerPix; c++ {
+			v := pixels.channel(p, c)
+			if !onlyAlpha && int8(c) == alphaChannel {
+				// Fully-transparent pixels never hold any data, and setting the bits to 1 keeps opaque pixels opaque
+				if !config.KeepAlpha && v > 0 {
+					pixels.setChannel(p, c, v | lowMask)
+				}
+				continue
+			}
+			if config.Noise > 0 {
+				shifted := int64(v) + r.Int63n(2 * int64(config.Noise) + 1) - int64(config.Noise)
+				if shifted < 0 {
+					shifted = 0
+				} else if shifted > max {
+					shifted = max
+				}
+				v = uint16(shifted)
+			}
+			v &^= lowMask
+			if !config.Zero {
+				v |= uint16(r.Uint32()) & lowMask
+			}
+			pixels.setChannel(p, c, v)
+		}
+		// Premultiplied colour values can't be larger than their alpha
+		if premultiplied {
+			a := pixels.channel(p, uint8(alphaChannel))
+			for c := uint8(0); c < info.Format.ChannelsPerPix; c++ {
+				if pixels.channel(p, c) > a {
+					pixels.setChannel(p, c, a)
+				}
+			}
+		}
+	}
+
+	quality := compareImages(original, pixels, info)
+	logger.Log(OutputInfo, fmt.Sprintf("PSNR after sanitizing: %.2f dB.", quality.PSNR))
+
+	logger.Log(OutputSteps, fmt.Sprintf("Writing the sanitized image to '%v' now...", config.OutPath))
+	if err = writeImage(pixels, config.OutPath, logger); err != nil {
+		logger.Log(OutputSteps, "An error occurred while writing to the final image.")
+		return nil, err
+	}
+
+	logger.Log(OutputSteps, "All done! c:")
+	return quality, nil
+}
@@ -0,0 +1,84 @@
+package steg
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/zedseven/steg/internal/gf256"
+)
+
+// Instead of being split, a file can be shared across several images with Shamir's secret sharing, so that any k of
+// the n images are enough to dig it up, while fewer than k reveal nothing about it. Each byte of the stored data is
+// the constant term of its own random polynomial of degree k - 1 over GF(2^8), and each image gets the evaluations of
+// the polynomials at its own x = 1, ..., n. Every share is as large as the stored data itself.
+//
+// The share is recorded in the part info of the steg header, with the part number as its index and the threshold k.
+
+// Error types
+
+// InsufficientSharesError is thrown when digging up a file that was shared across several images, but fewer than the
+// threshold of them were provided.
+type InsufficientSharesError struct {
+	// Have is the number of distinct shares that were provided.
+	Have      int
+	// Threshold is the number of shares needed to dig up the file.
+	Threshold int
+	// Count is the number of images the file was shared across.
+	Count     int
+}
+
+// Error returns a string that explains the InsufficientSharesError.
+func (e *InsufficientSharesError) Error() string {
+	return fmt.Sprintf("The file was shared across %d images, and any %d of them are needed to dig it up, but only %d " +
+		"were provided.", e.Count, e.Threshold, e.Have)
+}
+
+// Helper functions
+
+// makeShares splits data into n shares, any k of which are enough to rebuild it. Share i is for x = i + 1.
+func makeShares(data []byte, k, n int) ([][]byte, error) {
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(data))
+	}
+
+	// The coefficients of every polynomial but the constant term are random
+	coefficients := make([]byte, (k - 1) * len(data))
+	if _, err := rand.Read(coefficients); err != nil {
+		return nil, err
+	}
+
+	for j, secret := range data {
+		poly := coefficients[j * (k - 1):(j + 1) * (k - 1)]
+		for i := range shares {
+			x := byte(i + 1)
+			// Horner's method, from the highest coefficient down to the secret
+			y := byte(0)
+			for c := len(poly) - 1; c >= 0; c-- {
+				y = gf256.Add(gf256.Mul(y, x), poly[c])
+			}
+			shares[i][j] = gf256.Add(gf256.Mul(y, x), secret)
+		}
+	}
+	return shares, nil
+}
+
+// combineShares rebuilds the data from k shares, where indices holds the index of each share (as in makeShares).
+func combineShares(shares [][]byte, indices []uint8) ([]byte, error) {
+	xs := make([]byte, len(indices))
+	for i, index := range indices {
+		xs[i] = index + 1
+	}
+	weights := gf256.LagrangeWeights(xs, 0)
+
+	data := make([]byte, len(shares[0]))
+	for i, share := range shares {
+		if len(share) != len(data) {
+			return nil, &InvalidFormatError{"The shares of the file are not all the same size."}
+		}
+		for j, y := range share {
+			data[j] ^= gf256.Mul(weights[i], y)
+		}
+	}
+	return data, nil
+}
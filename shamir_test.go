@@ -0,0 +1,92 @@
+package steg
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// shamirSubsets is the number of random subsets of shares to try for each threshold, on top of the first and last k.
+const shamirSubsets = 20
+
+// Tests
+
+func TestShamirRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	data := make([]byte, 64)
+	r.Read(data)
+
+	tests := []struct {
+		k, n int
+	}{
+		{1, 1},
+		{1, 5},
+		{1, 255},
+		{2, 2},
+		{2, 3},
+		{3, 5},
+		{5, 9},
+		{16, 255},
+		{128, 255},
+		{254, 255},
+		{255, 255},
+	}
+	for _, test := range tests {
+		shares, err := makeShares(data, test.k, test.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(shares) != test.n {
+			t.Fatalf("k=%d, n=%d: got %d shares", test.k, test.n, len(shares))
+		}
+
+		for _, indices := range shareSubsets(r, test.k, test.n) {
+			subset := make([][]byte, len(indices))
+			for i, index := range indices {
+				subset[i] = shares[index]
+			}
+
+			got, err := combineShares(subset, indices)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("k=%d, n=%d: shares %v didn't rebuild the data.", test.k, test.n, indices)
+			}
+
+			// One share short of the threshold only gives a different polynomial through the remaining points
+			if test.k > 1 {
+				got, err = combineShares(subset[1:], indices[1:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if bytes.Equal(got, data) {
+					t.Errorf("k=%d, n=%d: shares %v rebuilt the data without share %d.", test.k, test.n,
+						indices[1:], indices[0])
+				}
+			}
+		}
+	}
+}
+
+// Helper functions
+
+// shareSubsets returns the first k share indices of n, the last k, and shamirSubsets random sets of k in a random
+// order.
+func shareSubsets(r *rand.Rand, k, n int) [][]uint8 {
+	first := make([]uint8, k)
+	last := make([]uint8, k)
+	for i := range first {
+		first[i] = uint8(i)
+		last[i] = uint8(n - k + i)
+	}
+	subsets := [][]uint8{first, last}
+	for s := 0; s < shamirSubsets; s++ {
+		indices := make([]uint8, k)
+		for i, index := range r.Perm(n)[:k] {
+			indices[i] = uint8(index)
+		}
+		subsets = append(subsets, indices)
+	}
+	return subsets
+}
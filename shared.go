@@ -8,6 +8,12 @@ import (
 	"io"
 	"math"
 	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zedseven/bch"
+	"github.com/zedseven/steg/internal/util"
 )
 
 const (
@@ -15,17 +21,20 @@ const (
 	encodeChunkSize       uint8  = 32
 	encodeHeaderSize      uint8  = 32
 	encodeHeaderSeparator string = ";"
+	// encodeHeaderErrors is the number of correctable errors that the header is always protected with, regardless of
+	// the ECC used for the file.
+	encodeHeaderErrors    uint8  = 16
 	// VersionMax is the primary version component of the package.
 	VersionMax            uint8  = 0
 	// VersionMid is the secondary version component of the package.
-	VersionMid            uint8  = 9
+	VersionMid            uint8  = 13
 	// VersionMin is the tertiary version component of the package.
 	VersionMin            uint8  = 0
 )
 
 // Shared types
 
-// OutputLevel is used to define the levels of output supported by the package.
+// OutputLevel is used to define the levels of output supported by the package, as used by a Logger.
 type OutputLevel int
 
 const (
@@ -40,8 +49,6 @@ const (
 )
 
 
-type pixel []uint16
-
 
 type fmtInfo struct {
 	Model          color.Model
@@ -159,6 +166,54 @@ func hashPatternFile(patternPath string) (int64, error) {
 	return int64(h.Sum64()), nil
 }
 
+// createECCConfig creates the BCH configuration for chunks of dataBytes bytes that can correct up to
+// maxCorrectableErrors bit errors each. If maxCorrectableErrors is 0, ECC is disabled and nil is returned.
+func createECCConfig(dataBytes int, maxCorrectableErrors uint8) (*bch.EncodingConfig, error) {
+	if maxCorrectableErrors <= 0 {
+		return nil, nil
+	}
+	codeLength, err := totalBitsForConfig(dataBytes * int(bitsPerByte), int(maxCorrectableErrors))
+	if err != nil {
+		return nil, err
+	}
+	return bch.CreateConfig(codeLength, int(maxCorrectableErrors))
+}
+
+// totalBitsForConfig returns the same code length as bch.TotalBitsForConfig, without trying every length one by one.
+// The number of ECC bits of a (shortened) BCH code only depends on the size of its Galois field, so it only has to be
+// found once for each field size, starting from the smallest one that fits the data.
+func totalBitsForConfig(dataLength, correctableErrors int) (int, error) {
+	for m := int(math.Log2(float64(dataLength + 1))) + 1; ; m++ {
+		minLength, maxLength := util.Max(1 << uint(m - 1), dataLength + 1), 1 << uint(m) - 1
+		storageBits, err := bch.StorageBitsForConfig(maxLength, correctableErrors)
+		if err != nil {
+			return -1, err
+		}
+		if storageBits <= 0 {
+			continue
+		}
+		codeLength := dataLength + maxLength - storageBits
+		if codeLength >= minLength && codeLength <= maxLength {
+			return codeLength, nil
+		}
+	}
+}
+
+// chunkCodeLength returns the number of bits a chunk of n bytes takes up once encoded.
+func chunkCodeLength(eccConfig *bch.EncodingConfig, n int) int {
+	length := n * int(bitsPerByte)
+	if eccConfig != nil {
+		length += checksumBits(eccConfig)
+	}
+	return length
+}
+
+// checksumBits returns the number of checksum bits of an ECC codeword. It's the same as eccConfig.ChecksumBits(), but
+// that has a value receiver, so calling it copies the whole EncodingConfig (tables and all) every time.
+func checksumBits(eccConfig *bch.EncodingConfig) int {
+	return eccConfig.CodeLength - eccConfig.StorageBits
+}
+
 // PCB = Pixel, Channel, Bit
 func bitAddrToPCB(addr int64, channels, bitsPerChannel uint8) (pix int64, channel, bit uint8) {
 	// Would normally floor here, but since all values are >= 0, integer division handles this for us
@@ -168,14 +223,49 @@ func bitAddrToPCB(addr int64, channels, bitsPerChannel uint8) (pix int64, channe
 	return
 }
 
+// forEachChunk calls work for every index in [0, n), spread across up to GOMAXPROCS goroutines. Each call must only
+// touch its own index of any shared slices. At the debug output level, everything runs on a single goroutine so that
+// the debug output stays in order. If the context of tracker is cancelled, no more work is started and its error is
+// returned. A panic in work is recovered on its goroutine and raised again on the calling one once the others have
+// finished, so that callers can recover it.
+func forEachChunk(tracker *progressTracker, n int, logger Logger, work func(i int)) error {
+	workers := util.Min(runtime.GOMAXPROCS(0), n)
+	if logger.Enabled(OutputDebug) || workers <= 1 {
+		for i := 0; i < n && tracker.err() == nil; i++ {
+			work(i)
+		}
+		return tracker.err()
+	}
+
+	var wg sync.WaitGroup
+	var panicOnce sync.Once
+	var panicked interface{}
+	next := int64(-1)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					panicOnce.Do(func() { panicked = r })
+					// Stops the other goroutines from taking any more work
+					atomic.StoreInt64(&next, int64(n))
+				}
+			}()
+			for i := int(atomic.AddInt64(&next, 1)); i < n && tracker.err() == nil; i = int(atomic.AddInt64(&next, 1)) {
+				work(i)
+			}
+		}()
+	}
+	wg.Wait()
+	if panicked != nil {
+		panic(panicked)
+	}
+	return tracker.err()
+}
+
 func posToXY(pos int64, w int) (x, y int) {
 	x = int(pos % int64(w))
 	y = int(pos / int64(w))
 	return
 }
-
-func printlnLvl(level, minLevel OutputLevel, val ...interface{}) {
-	if level >= minLevel {
-		fmt.Println(val...)
-	}
-}
\ No newline at end of file
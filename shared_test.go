@@ -0,0 +1,43 @@
+package steg
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/zedseven/bch"
+)
+
+// exhaustiveECC is whether to check every number of correctable errors, instead of a few. bch.TotalBitsForConfig tries
+// every code length one by one, so checking all of them takes a long time.
+var exhaustiveECC = flag.Bool("exhaustiveecc", false, "Whether to check totalBitsForConfig for every number of errors")
+
+// Tests
+
+// TestTotalBitsForConfig checks totalBitsForConfig against bch.TotalBitsForConfig, which it replaced, for the chunks
+// that are encoded with ECC (the header and the file chunks are the same size).
+func TestTotalBitsForConfig(t *testing.T) {
+	dataLength := int(encodeChunkSize) * int(bitsPerByte)
+	errorCounts := []int{1, 2, 4, 16}
+	if *exhaustiveECC {
+		errorCounts = nil
+		for errors := 1; errors <= 255; errors++ {
+			errorCounts = append(errorCounts, errors)
+		}
+	}
+
+	for _, errors := range errorCounts {
+		want, wantErr := bch.TotalBitsForConfig(dataLength, errors)
+		got, err := totalBitsForConfig(dataLength, errors)
+		if (err != nil) != (wantErr != nil) {
+			t.Fatalf("%d errors: got error %v, want %v", errors, err, wantErr)
+		}
+		if got != want {
+			t.Fatalf("%d errors: got a code length of %d, want %d", errors, got, want)
+		}
+		// No code can correct more errors than one that's already out of reach
+		if err != nil {
+			t.Logf("The codes run out at %d errors.", errors)
+			break
+		}
+	}
+}
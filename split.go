@@ -0,0 +1,197 @@
+package steg
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zedseven/steg/internal/util"
+)
+
+// A file that doesn't fit in one image can be split across several of them. Every image holds one part of the stored
+// data (after packing and compression), as an independent steg payload with its own ECC, and its steg header records
+// which part it is:
+//
+//	set ID (4 bytes), part number (1 byte, from 0), part count (1 byte), threshold (1 byte)
+//
+// The set ID is random, so that parts of different sets can't be mixed up. A file hidden in a single image is stored
+// as part 0 of a set of 1. The threshold is 0 for a split file, or the number of shares needed for a file that was
+// shared across the images instead (see shamir.go).
+
+const (
+	// partInfoSize is the size in bytes of the part info in a steg header.
+	partInfoSize int = 7
+	// maxParts is the maximum number of images a file can be split across.
+	maxParts     int = 255
+)
+
+// Types
+
+// partInfo describes which part of a split file an image holds.
+type partInfo struct {
+	setID     uint32
+	index     uint8
+	count     uint8
+	threshold uint8
+}
+
+// dugPart is the data dug up from a single image, before it's reassembled, decompressed or unpacked.
+type dugPart struct {
+	// imagePath is the image the part was dug up from.
+	imagePath string
+	data      []byte
+	part      partInfo
+	// opts are the Options with the layout settings from the steg header applied.
+	opts      *Options
+	// eccErrors is the number of errors that were corrected while digging it up.
+	eccErrors int
+}
+
+// MissingPartsError is thrown when digging up a file that was split across several images, but not all of them were
+// provided.
+type MissingPartsError struct {
+	// Missing holds the part numbers (starting from 1) of the images that are missing.
+	Missing []int
+	// Count is the number of images the file was split across.
+	Count   int
+}
+
+// Error returns a string that explains the MissingPartsError.
+func (e *MissingPartsError) Error() string {
+	strs := make([]string, len(e.Missing))
+	for i, n := range e.Missing {
+		strs[i] = fmt.Sprint(n)
+	}
+	return fmt.Sprintf("The file was split across %d images, but part(s) %v of them are missing.", e.Count,
+		strings.Join(strs, ", "))
+}
+
+// Helper functions
+
+// newSetID returns a random set ID for a new set of parts.
+func newSetID() (uint32, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return uint32(b[0]) << 24 | uint32(b[1]) << 16 | uint32(b[2]) << 8 | uint32(b[3]), nil
+}
+
+// marshal serializes the part info for a steg header.
+func (p partInfo) marshal() []byte {
+	return []byte{byte(p.setID >> 24), byte(p.setID >> 16), byte(p.setID >> 8), byte(p.setID), p.index, p.count,
+		p.threshold}
+}
+
+// parsePartInfo reads the part info from a steg header. Headers from before parts were recorded have a count of 0,
+// which is treated as a set of 1.
+func parsePartInfo(b []byte) (partInfo, error) {
+	p := partInfo{
+		setID:     uint32(b[0]) << 24 | uint32(b[1]) << 16 | uint32(b[2]) << 8 | uint32(b[3]),
+		index:     b[4],
+		count:     b[5],
+		threshold: b[6],
+	}
+	if p.count <= 0 {
+		p.count = 1
+	}
+	if p.index >= p.count || p.threshold > p.count {
+		return partInfo{}, &BadHeaderError{}
+	}
+	return p, nil
+}
+
+// splitPayload splits data into one part per image, in proportion to how much each of them can hold (capacities).
+// Every image gets a part, even if it's empty.
+func splitPayload(data []byte, capacities []int64) ([][]byte, error) {
+	total := int64(0)
+	for _, c := range capacities {
+		total += c
+	}
+	if int64(len(data)) > total {
+		return nil, &InsufficientHidingSpotsError{AdditionalInfo:fmt.Sprintf("The images can hold %d B between " +
+			"them, but the file takes up %d B.", total, len(data))}
+	}
+
+	sizes := make([]int64, len(capacities))
+	assigned := int64(0)
+	for i, c := range capacities {
+		if total > 0 {
+			sizes[i] = int64(len(data)) * c / total
+		}
+		assigned += sizes[i]
+	}
+	// Rounding down leaves a few bytes over, which go to whichever images still have room
+	for i := 0; assigned < int64(len(data)); i++ {
+		extra := util.Min64(capacities[i] - sizes[i], int64(len(data)) - assigned)
+		sizes[i] += extra
+		assigned += extra
+	}
+
+	parts := make([][]byte, len(capacities))
+	pos := int64(0)
+	for i, n := range sizes {
+		parts[i] = data[pos:pos + n]
+		pos += n
+	}
+	return parts, nil
+}
+
+// joinParts checks that parts form a complete set and joins their data in order, or combines them if they are shares.
+// The parts may be in any order.
+func joinParts(parts []dugPart) ([]byte, error) {
+	first := parts[0].part
+	for _, p := range parts[1:] {
+		if p.part.setID != first.setID || p.part.count != first.count || p.part.threshold != first.threshold {
+			return nil, &InvalidFormatError{fmt.Sprintf("The images '%v' and '%v' hold parts of different files.",
+				parts[0].imagePath, p.imagePath)}
+		}
+	}
+
+	byIndex := make(map[uint8]dugPart)
+	for _, p := range parts {
+		if other, ok := byIndex[p.part.index]; ok {
+			return nil, &InvalidFormatError{fmt.Sprintf("The images '%v' and '%v' hold the same part (%d).",
+				other.imagePath, p.imagePath, p.part.index + 1)}
+		}
+		byIndex[p.part.index] = p
+	}
+
+	if first.threshold > 0 {
+		if len(byIndex) < int(first.threshold) {
+			return nil, &InsufficientSharesError{Have: len(byIndex), Threshold: int(first.threshold),
+				Count: int(first.count)}
+		}
+		var shares [][]byte
+		var indices []uint8
+		for index, p := range byIndex {
+			if len(shares) < int(first.threshold) {
+				shares = append(shares, p.data)
+				indices = append(indices, index)
+			}
+		}
+		return combineShares(shares, indices)
+	}
+
+	var missing []int
+	for i := 0; i < int(first.count); i++ {
+		if _, ok := byIndex[uint8(i)]; !ok {
+			missing = append(missing, i + 1)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &MissingPartsError{Missing: missing, Count: int(first.count)}
+	}
+
+	sorted := make([]dugPart, 0, len(parts))
+	for _, p := range byIndex {
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].part.index < sorted[j].part.index })
+	var data []byte
+	for _, p := range sorted {
+		data = append(data, p.data...)
+	}
+	return data, nil
+}
@@ -0,0 +1,156 @@
+package steg
+
+import (
+	"fmt"
+	"math"
+)
+
+// The statistics of an image describe how suitable it is as a carrier. Data hides best in images with plenty of
+// texture and noise, so flat images (few distinct values, little difference between neighbouring pixels), images that
+// are mostly transparent (since fully-transparent pixels are never used), and images that are saturated at the ends of
+// the range of values all make poor carriers. They're computed over the colour channels of the pixels that aren't
+// fully transparent, which are the ones that data would be hidden in.
+
+// Types
+
+// StatsReport is the statistical fingerprint of an image.
+type StatsReport struct {
+	// Pixels is the number of pixels in the image.
+	Pixels            int64
+	// TransparentPixels is the number of fully-transparent pixels, which data is never hidden in.
+	TransparentPixels int64
+	// Channels holds the statistics of each colour channel.
+	Channels          []ChannelStats
+}
+
+// ChannelStats is the statistical fingerprint of one colour channel of an image.
+type ChannelStats struct {
+	// Channel is the name of the colour channel.
+	Channel       string
+	// Histogram holds the number of times that every value appears, from 0 to the largest value of the channel.
+	Histogram     []int64
+	// Min is the smallest value that appears.
+	Min           uint16
+	// Max is the largest value that appears.
+	Max           uint16
+	// Mean is the mean of the values.
+	Mean          float64
+	// StdDev is the standard deviation of the values.
+	StdDev        float64
+	// Distinct is the number of different values that appear.
+	Distinct      int
+	// Saturated is the fraction of the values that are at either end of the range (0 or the largest value).
+	Saturated     float64
+	// LSBRatio is the fraction of the values whose least-significant bit is 1.
+	LSBRatio      float64
+	// PairAsymmetry is how different the counts of each pair of values 2k and 2k + 1 are, as the sum of their absolute
+	// differences over the number of values. Embedding data in the least-significant bits evens the pairs out, pushing
+	// it towards 0, while clean images usually have some asymmetry.
+	PairAsymmetry float64
+	// Texture is the mean absolute difference between horizontally adjacent values, as a fraction of the range of
+	// values. Flat images have very little of it.
+	Texture       float64
+}
+
+// Library methods
+
+// Stats computes the statistical fingerprint of the image at imagePath.
+func Stats(imagePath string, logger Logger) (*StatsReport, error) {
+	logger = loggerOrNop(logger)
+
+	if len(imagePath) <= 0 {
+		return nil, &InvalidFormatError{"ImagePath is empty."}
+	}
+
+	logger.Log(OutputSteps, fmt.Sprintf("Loading the image from '%v'...", imagePath))
+	pixels, info, err := loadImage(imagePath, logger)
+	if err = checkLoaded(pixels, err); err != nil {
+		logger.Log(OutputSteps, fmt.Sprintf("Unable to load the image at '%v'!", imagePath))
+		return nil, err
+	}
+
+	logger.Log(OutputSteps, "Computing the statistics of the image...")
+	return imageStats(pixels, info), nil
+}
+
+// Helper functions
+
+// imageStats computes the statistical fingerprint of a loaded image.
+func imageStats(pixels *pixelBuffer, info imgInfo) *StatsReport {
+	report := &StatsReport{Pixels: pixels.count()}
+	supportsAlpha := info.Format.supportsAlpha()
+	alphaChannel := info.Format.alphaChannel()
+	opaque := make([]bool, pixels.count())
+	for p := range opaque {
+		opaque[p] = !supportsAlpha || pixels.channel(int64(p), uint8(alphaChannel)) > 0
+		if !opaque[p] {
+			report.TransparentPixels++
+		}
+	}
+
+	names := channelNames(info.Format.Model)
+	top := int(uint32(1) << info.Format.BitsPerChannel - 1)
+	w := int64(info.W)
+	for c := uint8(0); c < info.Format.ChannelsPerPix; c++ {
+		if supportsAlpha && int8(c) == alphaChannel {
+			continue
+		}
+
+		s := ChannelStats{Channel: names[c], Histogram: make([]int64, top + 1)}
+		var sum, sumSq, texture float64
+		var n, neighbours int64
+		for p := int64(0); p < pixels.count(); p++ {
+			if !opaque[p] {
+				continue
+			}
+			v := pixels.channel(p, c)
+			s.Histogram[v]++
+			sum += float64(v)
+			sumSq += float64(v) * float64(v)
+			n++
+			if p % w > 0 && opaque[p - 1] {
+				texture += math.Abs(float64(v) - float64(pixels.channel(p - 1, c)))
+				neighbours++
+			}
+		}
+		if n <= 0 {
+			report.Channels = append(report.Channels, s)
+			continue
+		}
+
+		s.Min, s.Max = uint16(top), 0
+		var ones, asymmetry int64
+		for v, count := range s.Histogram {
+			if count <= 0 {
+				continue
+			}
+			s.Distinct++
+			if uint16(v) < s.Min {
+				s.Min = uint16(v)
+			}
+			if uint16(v) > s.Max {
+				s.Max = uint16(v)
+			}
+			if v & 1 == 1 {
+				ones += count
+			}
+		}
+		for k := 0; k + 1 <= top; k += 2 {
+			diff := s.Histogram[k] - s.Histogram[k + 1]
+			if diff < 0 {
+				diff = -diff
+			}
+			asymmetry += diff
+		}
+		s.Mean = sum / float64(n)
+		s.StdDev = math.Sqrt(math.Max(sumSq / float64(n) - s.Mean * s.Mean, 0))
+		s.Saturated = float64(s.Histogram[0] + s.Histogram[top]) / float64(n)
+		s.LSBRatio = float64(ones) / float64(n)
+		s.PairAsymmetry = float64(asymmetry) / float64(n)
+		if neighbours > 0 {
+			s.Texture = texture / float64(neighbours) / float64(top)
+		}
+		report.Channels = append(report.Channels, s)
+	}
+	return report
+}
@@ -0,0 +1,150 @@
+package steg
+
+import (
+	"github.com/zedseven/binmani"
+	"github.com/zedseven/steg/internal/algos"
+)
+
+// Types
+
+// bitStream reads and writes individual bits to the pixels of an image, in the order handed out by an algorithm
+// addressor. Addresses that fall on unusable pixels (fully transparent, or outside of the mask) are skipped, and so are
+// reserved ones.
+type bitStream struct {
+	pixels         *pixelBuffer
+	info           imgInfo
+	mask           pixelMask
+	pos            func() (int64, error)
+	channels       uint8
+	bitsPerChannel uint8
+	msb            bool
+	// reserved are the bit addresses to skip over, which are kept for the ephemeral public key of another layer, or
+	// already hold the steg header.
+	reserved       map[int64]bool
+	// claim is whether to add every address handed out to reserved, so that the addresses can be handed out again
+	// from the start without any of them being used twice.
+	claim          bool
+	logger         Logger
+}
+
+// Primary methods
+
+// writeBits writes each of bits to the next available bit addresses in the stream.
+func (s *bitStream) writeBits(bits []uint8) error {
+	trace := s.logger.Enabled(OutputDebug)
+	for i := range bits {
+		addr, p, c, bitPos, err := s.next()
+		if err != nil {
+			return err
+		}
+
+		before := s.pixels.channel(p, c)
+		s.pixels.setChannel(p, c, binmani.WriteTo(before, bitPos, 1, uint16(bits[i])))
+
+		if trace {
+			s.logger.Log(OutputDebug, "Wrote bit.", "addr", addr, "pixel", p, "channel", c, "bit", bitPos,
+				"value", bits[i], "before", before, "after", s.pixels.channel(p, c))
+		}
+	}
+
+	return nil
+}
+
+// readBits reads n bits from the next available bit addresses in the stream.
+func (s *bitStream) readBits(n int) ([]uint8, error) {
+	trace := s.logger.Enabled(OutputDebug)
+	bits := make([]uint8, n)
+	for i := range bits {
+		addr, p, c, bitPos, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+
+		bits[i] = uint8(binmani.ReadFrom(s.pixels.channel(p, c), bitPos, 1))
+
+		if trace {
+			s.logger.Log(OutputDebug, "Read bit.", "addr", addr, "pixel", p, "channel", c, "bit", bitPos,
+				"value", bits[i])
+		}
+	}
+
+	return bits, nil
+}
+
+// seekHeaderCopy moves a sequential stream to the start of the share of the pool that header copy i of copies goes in,
+// so that the copies are spread across the image instead of sitting back to back, where the same bit of damage would
+// take out all of them. The addresses that the copies take up are claimed, and skipped over from then on.
+func (s *bitStream) seekHeaderCopy(i, copies int) {
+	s.pos = algos.OffsetSequentialAddressor(int64(i) * s.poolSize() / int64(copies), s.channelCount(), s.bitsPerChannel)
+	s.claim = true
+}
+
+// seekData moves a sequential stream back to the start of the pool, for the file data to follow the header in the
+// addresses that are left. If the data is interleaved, its n bits are spread evenly across the whole pool instead of
+// being packed in at the start, since interleaving doesn't do anything against damage to the region they'd be in.
+func (s *bitStream) seekData(interleaved bool, n int64) {
+	stride := int64(1)
+	if interleaved && n > 0 {
+		stride = s.poolSize() / n
+	}
+	s.pos = algos.StridedAddressor(stride, s.channelCount(), s.bitsPerChannel)
+	s.claim = false
+}
+
+// Helper functions
+
+// channelCount returns the number of channels that the stream hides data in.
+func (s *bitStream) channelCount() int64 {
+	return s.pixels.count() * int64(s.channels)
+}
+
+// poolSize returns the number of bit addresses in the pool of the stream.
+func (s *bitStream) poolSize() int64 {
+	return s.channelCount() * int64(s.bitsPerChannel)
+}
+
+// next returns the bit address, and the pixel, channel and bit position within the channel value it points to, of the
+// next usable bit address.
+func (s *bitStream) next() (addr, p int64, c, bitPos uint8, err error) {
+	supportsAlpha := s.info.Format.supportsAlpha()
+	alphaChannel := s.info.Format.alphaChannel()
+
+	for {
+		addr, err = s.pos()
+		if err != nil {
+			return -1, -1, 0, 0, err
+		}
+		p, c, b := bitAddrToPCB(addr, s.channels, s.bitsPerChannel)
+
+		if s.reserved[addr] {
+			continue
+		}
+		// TODO: Note that this has the potential to introduce nasty bugs if a (0,0,0,1) is turned into a (0,0,0,0)
+		if supportsAlpha && s.pixels.channel(p, uint8(alphaChannel)) <= 0 {
+			if s.logger.Enabled(OutputDebug) {
+				s.logger.Log(OutputDebug, "Skipped a transparent pixel.", "addr", addr, "pixel", p, "channel", c, "bit", b)
+			}
+			continue
+		}
+		if !s.mask.allows(p) {
+			if s.logger.Enabled(OutputDebug) {
+				s.logger.Log(OutputDebug, "Skipped a masked pixel.", "addr", addr, "pixel", p, "channel", c, "bit", b)
+			}
+			continue
+		}
+
+		if s.claim {
+			if s.reserved == nil {
+				s.reserved = make(map[int64]bool)
+			}
+			s.reserved[addr] = true
+		}
+
+		bitPos = b
+		if s.msb {
+			bitPos = s.info.Format.BitsPerChannel - b - 1
+		}
+
+		return addr, p, c, bitPos, nil
+	}
+}
@@ -0,0 +1,65 @@
+package steg
+
+import (
+	"os"
+	"testing"
+)
+
+// Tests
+
+// TestSeekData checks that the file data of a sequential stream never lands on the header, and that interleaved data
+// is spread across the whole pool.
+func TestSeekData(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	pixels, info, err := loadImage(writeTestImage(t, dir), loggerOrNop(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const headerBits = 400
+	tests := []struct {
+		interleaved bool
+		n           int64
+	}{
+		{false, 1},
+		{false, 1000},
+		{true, 1},
+		{true, 7},
+		{true, 1000},
+		{true, 65537},
+		{true, 3 * testImageSize * testImageSize - headerBits},
+	}
+	for _, test := range tests {
+		stream := &bitStream{pixels: pixels, info: info, channels: 3, bitsPerChannel: 1, logger: loggerOrNop(nil)}
+		stream.seekHeaderCopy(0, 1)
+		if _, err = stream.readBits(headerBits); err != nil {
+			t.Fatal(err)
+		}
+
+		stream.seekData(test.interleaved, test.n)
+		seen := make(map[int64]bool)
+		var first, last int64
+		for i := int64(0); i < test.n; i++ {
+			addr, _, _, _, err := stream.next()
+			if err != nil {
+				t.Fatalf("interleaved: %v, n = %d: ran out after %d addresses: %v", test.interleaved, test.n, i, err)
+			}
+			if addr < headerBits || seen[addr] {
+				t.Fatalf("interleaved: %v, n = %d: address %d was handed out twice", test.interleaved, test.n, addr)
+			}
+			seen[addr] = true
+			if i == 0 {
+				first = addr
+			}
+			last = addr
+		}
+
+		switch {
+		case !test.interleaved && (first != headerBits || last != headerBits + test.n - 1):
+			t.Errorf("n = %d: the data went in %d to %d, want right after the header", test.n, first, last)
+		case test.interleaved && test.n > 1 && last < stream.poolSize() / 2:
+			t.Errorf("interleaved, n = %d: the data only went up to %d of %d", test.n, last, stream.poolSize())
+		}
+	}
+}